@@ -0,0 +1,470 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xxs-2/jattach-go/internal/process"
+)
+
+// ChangeKind is the kind of setting a Change modifies
+type ChangeKind string
+
+const (
+	// ChangeFlag is a manageable VM flag changed with setflag
+	ChangeFlag ChangeKind = "flag"
+
+	// ChangeLogging is a unified logging output configured with VM.log
+	ChangeLogging ChangeKind = "logging"
+)
+
+// Change is a temporary change to a running JVM that is reverted after its
+// TTL or when Undo is called, whichever comes first
+// Pending changes are recorded in the state file (Options.StateFile) before
+// they are applied, so that RestoreChanges can revert them if the process
+// that made them exits first
+type Change struct {
+	ID   string     `json:"id"`
+	PID  int        `json:"pid"`
+	Kind ChangeKind `json:"kind"`
+
+	// StartTime is the start time of the target process, which tells it
+	// apart from a later process reusing the PID. It is zero where the
+	// start time is not available
+	StartTime uint64 `json:"start_time,omitempty"`
+
+	// Name is the flag name, or the log output ("stdout", "stderr" or
+	// "file=<path>")
+	Name string `json:"name"`
+
+	// Value is the new flag value, or the log selection (what=) and
+	// Decorators its decorators
+	Value      string `json:"value"`
+	Decorators string `json:"decorators,omitempty"`
+
+	// Previous and PreviousDecorators are restored by Undo
+	Previous           string `json:"previous"`
+	PreviousDecorators string `json:"previous_decorators,omitempty"`
+
+	// NewOutput is set when the change created the log output, which Undo
+	// then turns off
+	NewOutput bool `json:"new_output,omitempty"`
+
+	// Expires is when the change is reverted, nil for no TTL
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Owner is the PID of the process that reverts the change on expiry
+	Owner int `json:"owner"`
+
+	client *Client
+	mu     sync.Mutex
+	timer  *time.Timer
+	done   chan struct{}
+	err    error
+}
+
+// ChangeFlag sets a manageable VM flag until ttl elapses or Undo is called
+// A ttl of zero keeps the change until Undo or RestoreChanges
+func (c *Client) ChangeFlag(ctx context.Context, pid int, name, value string, ttl time.Duration) (*Change, error) {
+	flags, err := c.changeClient(pid).Flags(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var flag *Flag
+	for i := range flags {
+		if flags[i].Name == name {
+			flag = &flags[i]
+			break
+		}
+	}
+	if flag == nil || !flag.Manageable {
+		return nil, wrapError(CmdSetFlag, pid, &FlagError{Name: name, Flag: flag})
+	}
+
+	ch := c.newChange(pid, ChangeFlag, name, ttl)
+	ch.Value = value
+	ch.Previous = flag.Value
+	if err := c.applyChange(ctx, ch); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// ChangeLogging configures a unified logging output with VM.log until ttl
+// elapses or Undo is called. output is "stdout", "stderr" or a file name,
+// what is the log selection (e.g. "gc*=debug"), which replaces the current
+// one of the output, and decorators may be empty to keep the defaults
+// Undo restores the previous configuration of the output, or turns it off
+// if the change created it. A ttl of zero keeps the change until Undo or
+// RestoreChanges
+func (c *Client) ChangeLogging(ctx context.Context, pid int, output, what, decorators string, ttl time.Duration) (*Change, error) {
+	if output != "stdout" && output != "stderr" && !strings.HasPrefix(output, "file=") {
+		output = "file=" + output
+	}
+
+	list, _, err := c.changeClient(pid).command(ctx, pid, CmdJCmd, "VM.log list")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := c.newChange(pid, ChangeLogging, output, ttl)
+	ch.Value = what
+	ch.Decorators = decorators
+	if prev, ok := parseLogOutputs(list)[output]; ok {
+		ch.Previous = prev.what
+		ch.PreviousDecorators = prev.decorators
+	} else {
+		ch.NewOutput = true
+	}
+	if err := c.applyChange(ctx, ch); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (c *Client) newChange(pid int, kind ChangeKind, name string, ttl time.Duration) *Change {
+	ch := &Change{
+		ID:     changeID(),
+		PID:    pid,
+		Kind:   kind,
+		Name:   name,
+		Owner:  os.Getpid(),
+		client: c,
+		done:   make(chan struct{}),
+	}
+	ch.StartTime, _ = process.StartTime(c.options.ProcRoot, pid)
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		ch.Expires = &expires
+	}
+	return ch
+}
+
+// changeClient returns the client that attaches to pid for a change. An
+// attach in process to a JVM of another user switches the credentials of
+// the whole process for good, which would lock it out of the state file
+// it has just written, so such attaches go through a helper
+func (c *Client) changeClient(pid int) *Client {
+	if c.options.Helper || !needsHelper(c.options.ProcRoot, pid) {
+		return c
+	}
+	helper := *c.options
+	helper.Helper = true
+	return NewClientWithOptions(&helper)
+}
+
+// applyChange records the change, applies it and schedules its revert
+func (c *Client) applyChange(ctx context.Context, ch *Change) error {
+	// Record first: if the process dies right after applying, the revert
+	// is still known
+	err := c.updateState(func(changes []*Change) []*Change {
+		return append(changes, ch)
+	})
+	if err != nil {
+		return fmt.Errorf("recording change: %w", err)
+	}
+
+	if err := c.setChange(ctx, ch, false); err != nil {
+		c.forgetChange(ch)
+		return err
+	}
+
+	ch.schedule()
+	return nil
+}
+
+// setChange applies the new value of the change, or the previous one if
+// revert is set
+func (c *Client) setChange(ctx context.Context, ch *Change, revert bool) error {
+	attacher := c.changeClient(ch.PID)
+	switch ch.Kind {
+	case ChangeFlag:
+		value := ch.Value
+		if revert {
+			value = ch.Previous
+		}
+		_, _, err := attacher.command(ctx, ch.PID, CmdSetFlag, ch.Name, value)
+		return err
+
+	case ChangeLogging:
+		what, decorators := ch.Value, ch.Decorators
+		if revert {
+			what, decorators = ch.Previous, ch.PreviousDecorators
+			if ch.NewOutput {
+				// A file output with everything off is removed
+				what, decorators = "all=off", ""
+			}
+		}
+		cmd := fmt.Sprintf("VM.log output=%s what=%s", ch.Name, what)
+		if decorators != "" {
+			cmd += " decorators=" + decorators
+		}
+		_, _, err := attacher.command(ctx, ch.PID, CmdJCmd, cmd)
+		return err
+	}
+
+	return fmt.Errorf("unknown change kind %q", ch.Kind)
+}
+
+// schedule arms the revert timer of the change
+func (ch *Change) schedule() {
+	if ch.Expires == nil {
+		return
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.timer = time.AfterFunc(time.Until(*ch.Expires), func() {
+		if err := ch.Undo(); err != nil && ch.client.options.Logger != nil {
+			ch.client.options.Logger.Printf("Warning: reverting %s %s of pid %d: %v", ch.Kind, ch.Name, ch.PID, err)
+		}
+	})
+}
+
+// Undo reverts the change now. It is safe to call more than once and after
+// the TTL has elapsed. If the revert fails, the change stays pending and
+// Undo may be called again
+func (ch *Change) Undo() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	select {
+	case <-ch.done:
+		return nil
+	default:
+	}
+
+	if ch.timer != nil {
+		ch.timer.Stop()
+	}
+
+	var err error
+	if ch.client.targetReplaced(ch) {
+		err = ErrProcessNotFound
+	} else {
+		err = ch.client.setChange(context.Background(), ch, true)
+	}
+	if errors.Is(err, ErrProcessNotFound) {
+		// The JVM is gone and its settings with it
+		err = nil
+	}
+	if err == nil {
+		err = ch.client.forgetChange(ch)
+	}
+
+	ch.err = err
+	if err == nil {
+		close(ch.done)
+	}
+	return err
+}
+
+// Done is closed once the change has been reverted
+func (ch *Change) Done() <-chan struct{} {
+	return ch.done
+}
+
+// Err returns the error of the last failed revert, nil once reverted
+func (ch *Change) Err() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.err
+}
+
+// PendingChanges returns the changes recorded in the state file that have
+// not been reverted yet, by any process. Undo on one of them reverts it
+// through this client, even if another process made it
+func (c *Client) PendingChanges() ([]*Change, error) {
+	var pending []*Change
+	err := c.updateState(func(changes []*Change) []*Change {
+		pending = changes
+		return changes
+	})
+	for _, ch := range pending {
+		ch.client = c
+		ch.done = make(chan struct{})
+	}
+	return pending, err
+}
+
+// targetReplaced reports whether the process the change was made to has
+// exited and its PID has been reused since, so that reverting the change
+// would modify an unrelated JVM
+func (c *Client) targetReplaced(ch *Change) bool {
+	if ch.StartTime == 0 {
+		return false
+	}
+	startTime, err := process.StartTime(c.options.ProcRoot, ch.PID)
+	return err == nil && startTime != ch.StartTime
+}
+
+// RestoreChanges takes over the pending changes whose owner process has
+// exited, for example because it crashed: expired ones are reverted now,
+// the others when they expire. Changes without a TTL are reverted now
+// Changes to a process whose PID now belongs to another process are
+// dropped without a revert. It returns the changes taken over
+func (c *Client) RestoreChanges(ctx context.Context) ([]*Change, error) {
+	var orphans []*Change
+	err := c.updateState(func(changes []*Change) []*Change {
+		kept := changes[:0]
+		for _, ch := range changes {
+			if ch.Owner == os.Getpid() || processAlive(ch.Owner) {
+				kept = append(kept, ch)
+				continue
+			}
+			if c.targetReplaced(ch) {
+				continue
+			}
+			ch.Owner = os.Getpid()
+			ch.client = c
+			ch.done = make(chan struct{})
+			orphans = append(orphans, ch)
+			kept = append(kept, ch)
+		}
+		return kept
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ch := range orphans {
+		if ch.Expires == nil || time.Now().After(*ch.Expires) {
+			if err := ch.Undo(); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		ch.schedule()
+	}
+
+	return orphans, errors.Join(errs...)
+}
+
+// forgetChange removes a reverted change from the state file
+func (c *Client) forgetChange(ch *Change) error {
+	return c.updateState(func(changes []*Change) []*Change {
+		kept := changes[:0]
+		for _, other := range changes {
+			if other.ID != ch.ID {
+				kept = append(kept, other)
+			}
+		}
+		return kept
+	})
+}
+
+// stateFile returns the path of the file holding pending changes
+func (c *Client) stateFile() (string, error) {
+	if c.options.StateFile != "" {
+		return c.options.StateFile, nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "jattach", "changes.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "jattach", "changes.json"), nil
+}
+
+// updateState reads the state file, lets fn modify the changes and writes
+// the result back, holding a lock against other processes
+func (c *Client) updateState(fn func(changes []*Change) []*Change) error {
+	path, err := c.stateFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	var changes []*Change
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &changes); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	changes = fn(changes)
+
+	data, err = json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a new file and rename it, so a crash never leaves half a file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// logOutput is the configuration of a unified logging output
+type logOutput struct {
+	what       string
+	decorators string
+}
+
+// logOutputLine matches an output of VM.log list
+// #2: file=/tmp/gc.log all=off,gc=debug uptime,level,tags filecount=5,filesize=20M
+var logOutputLine = regexp.MustCompile(`^\s*#\d+: (\S+) (\S+) (\S+)`)
+
+// parseLogOutputs parses the output configuration printed by VM.log list
+func parseLogOutputs(list string) map[string]logOutput {
+	outputs := make(map[string]logOutput)
+	for _, line := range strings.Split(list, "\n") {
+		if m := logOutputLine.FindStringSubmatch(line); m != nil {
+			outputs[strings.Trim(m[1], `"`)] = logOutput{what: m[2], decorators: m[3]}
+		}
+	}
+	return outputs
+}
+
+func changeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
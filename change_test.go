@@ -0,0 +1,245 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+// newChangeClient starts a fake HotSpot JVM and a client recording its
+// changes in a temporary state file
+func newChangeClient(t *testing.T) (*jattach.Client, *jattachtest.Server, string) {
+	t.Helper()
+	_, jvm := newHotSpot(t, jattachtest.HotSpot17())
+
+	stateFile := filepath.Join(t.TempDir(), "changes.json")
+	return newClient(jvm, &jattach.Options{StateFile: stateFile}), jvm, stateFile
+}
+
+// setflags returns the setflag commands received by jvm
+func setflags(jvm *jattachtest.Server) []string {
+	var set []string
+	for _, cmd := range jvm.Commands() {
+		if cmd.Name == "setflag" {
+			set = append(set, strings.Join(cmd.Args, "="))
+		}
+	}
+	return set
+}
+
+func TestChangeFlagUndo(t *testing.T) {
+	client, jvm, stateFile := newChangeClient(t)
+	ctx := context.Background()
+
+	change, err := client.ChangeFlag(ctx, jvm.PID(), "PrintConcurrentLocks", "true", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Previous != "false" || change.Expires != nil {
+		t.Errorf("change = previous %q, expires %v", change.Previous, change.Expires)
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "expires") {
+		t.Errorf("change without TTL recorded with an expiry:\n%s", data)
+	}
+	pending, err := client.PendingChanges()
+	if err != nil || len(pending) != 1 || pending[0].ID != change.ID {
+		t.Fatalf("PendingChanges() = %v, %v", pending, err)
+	}
+
+	if err := change.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := change.Undo(); err != nil {
+		t.Errorf("second Undo() = %v", err)
+	}
+	if got := setflags(jvm); strings.Join(got, " ") != "PrintConcurrentLocks=true PrintConcurrentLocks=false" {
+		t.Errorf("setflag commands = %q", got)
+	}
+	if pending, err := client.PendingChanges(); err != nil || len(pending) != 0 {
+		t.Errorf("PendingChanges() after Undo = %v, %v", pending, err)
+	}
+}
+
+func TestChangeFlagExpires(t *testing.T) {
+	client, jvm, _ := newChangeClient(t)
+
+	change, err := client.ChangeFlag(context.Background(), jvm.PID(), "PrintConcurrentLocks", "true", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Expires == nil {
+		t.Fatal("change with TTL has no expiry")
+	}
+
+	select {
+	case <-change.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not reverted after its TTL")
+	}
+	if err := change.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := setflags(jvm); len(got) != 2 || got[1] != "PrintConcurrentLocks=false" {
+		t.Errorf("setflag commands = %q", got)
+	}
+}
+
+func TestChangeFlagNotManageable(t *testing.T) {
+	client, jvm, _ := newChangeClient(t)
+
+	_, err := client.ChangeFlag(context.Background(), jvm.PID(), "UseG1GC", "false", 0)
+	if err == nil {
+		t.Fatal("ChangeFlag of a product flag succeeded")
+	}
+	if got := setflags(jvm); len(got) != 0 {
+		t.Errorf("setflag sent: %q", got)
+	}
+	if pending, _ := client.PendingChanges(); len(pending) != 0 {
+		t.Errorf("refused change recorded: %v", pending)
+	}
+}
+
+func TestPendingChangesUndo(t *testing.T) {
+	client, jvm, stateFile := newChangeClient(t)
+	if _, err := client.ChangeFlag(context.Background(), jvm.PID(), "PrintConcurrentLocks", "true", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another client, as in a later run of the command, reverts the change
+	other := newClient(jvm, &jattach.Options{StateFile: stateFile})
+	pending, err := other.PendingChanges()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("PendingChanges() = %v, %v", pending, err)
+	}
+	if err := pending[0].Undo(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-pending[0].Done():
+	default:
+		t.Error("Done() not closed after Undo")
+	}
+	if got := setflags(jvm); len(got) != 2 || got[1] != "PrintConcurrentLocks=false" {
+		t.Errorf("setflag commands = %q", got)
+	}
+}
+
+func TestChangeLogging(t *testing.T) {
+	client, jvm, _ := newChangeClient(t)
+	ctx := context.Background()
+
+	stdout, err := client.ChangeLogging(ctx, jvm.PID(), "stdout", "gc*=debug", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Previous != "all=warning" || stdout.PreviousDecorators != "uptime,level,tags" || stdout.NewOutput {
+		t.Errorf("stdout change = %+v", stdout)
+	}
+	file, err := client.ChangeLogging(ctx, jvm.PID(), "/tmp/gc.log", "gc=info", "uptime", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "file=/tmp/gc.log" || !file.NewOutput {
+		t.Errorf("file change = %+v", file)
+	}
+
+	if err := stdout.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, cmd := range jvm.Commands() {
+		if cmd.Key() == "jcmd VM.log" {
+			got = append(got, cmd.Args[0])
+		}
+	}
+	want := []string{
+		"VM.log list",
+		"VM.log output=stdout what=gc*=debug",
+		"VM.log list",
+		"VM.log output=file=/tmp/gc.log what=gc=info decorators=uptime",
+		"VM.log output=stdout what=all=warning decorators=uptime,level,tags",
+		"VM.log output=file=/tmp/gc.log what=all=off",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("VM.log commands = %q, want %q", got, want)
+	}
+}
+
+func TestRestoreChanges(t *testing.T) {
+	client, jvm, stateFile := newChangeClient(t)
+
+	// A reaped child is an owner that has exited
+	child := exec.Command("true")
+	if err := child.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dead, live := child.Process.Pid, os.Getppid()
+
+	later := time.Now().Add(time.Hour)
+	changes := []*jattach.Change{
+		{ID: "expired", PID: jvm.PID(), Kind: jattach.ChangeFlag, Name: "PrintConcurrentLocks", Value: "true", Previous: "false", Owner: dead},
+		{ID: "scheduled", PID: jvm.PID(), Kind: jattach.ChangeFlag, Name: "PrintConcurrentLocks", Value: "true", Previous: "false", Expires: &later, Owner: dead},
+		{ID: "owned", PID: jvm.PID(), Kind: jattach.ChangeFlag, Name: "HeapDumpOnOutOfMemoryError", Value: "true", Previous: "false", Owner: live},
+		// The PID of the target now belongs to another process
+		{ID: "reused", PID: jvm.PID(), StartTime: 1, Kind: jattach.ChangeFlag, Name: "PrintConcurrentLocks", Value: "false", Previous: "true", Owner: dead},
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stateFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := client.RestoreChanges(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || restored[0].ID != "expired" || restored[1].ID != "scheduled" {
+		t.Fatalf("RestoreChanges() = %+v", restored)
+	}
+	if restored[1].Owner != os.Getpid() {
+		t.Errorf("scheduled change owned by %d", restored[1].Owner)
+	}
+	if got := setflags(jvm); len(got) != 1 || got[0] != "PrintConcurrentLocks=false" {
+		t.Errorf("setflag commands = %q", got)
+	}
+
+	pending, err := client.PendingChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, ch := range pending {
+		ids = append(ids, ch.ID)
+	}
+	if strings.Join(ids, " ") != "scheduled owned" {
+		t.Errorf("pending changes = %q, want [scheduled owned]", ids)
+	}
+
+	if err := restored[1].Undo(); err != nil {
+		t.Fatal(err)
+	}
+}
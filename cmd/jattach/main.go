@@ -0,0 +1,250 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Command jattach sends a command to a running JVM through the Dynamic
+// Attach mechanism. Arguments, output and exit codes are those of the C
+// jattach: the exit code is the JVM's return code, or 1 if attaching failed
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+)
+
+const usage = `jattach-go
+
+Usage: jattach [options] <pid> <cmd> [args ...]
+
+Commands:
+    load  threaddump   dumpheap  setflag    properties
+    jcmd  inspectheap  datadump  printflag  agentProperties
+
+Options:
+    --timeout <duration>  Timeout of each attach phase, e.g. 10s (default 6s)
+    --read-timeout <duration>
+                          Timeout of the wait for the response (default
+                          none, since dumpheap may take long)
+    --tmp-path <path>     Temporary directory of the target (or JATTACH_PATH)
+    --proc-root <path>    Mount point of the host procfs (or JATTACH_PROC_ROOT)
+    --namespaces <mode>   auto, enter or none: whether to setns into the
+                          target's namespaces (default auto)
+    --reply-address <ip>  Address awaiting OpenJ9 targets (default loopback)
+    --quiet               Print nothing but errors
+    --json                Print the result as a JSON object, with the parsed
+                          body for thread dumps, histograms, properties
+                          and VM.flags -all
+`
+
+// config holds the parsed command line
+type config struct {
+	timeout  time.Duration
+	readTime time.Duration
+	tmpPath  string
+	procRoot string
+	nsMode   jattach.NamespaceMode
+	replyIP  string
+	quiet    bool
+	json     bool
+	pid      int
+	cmd      string
+	args     []string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Print(usage)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := jattach.NewClientWithOptions(&jattach.Options{
+		PrintOutput:   !cfg.quiet && !cfg.json,
+		TmpPath:       cfg.tmpPath,
+		ProcRoot:      cfg.procRoot,
+		NamespaceMode: cfg.nsMode,
+		ReplyAddress:  cfg.replyIP,
+		Timeout:       cfg.timeout,
+		ReadTimeout:   cfg.readTime,
+	})
+
+	resp, err := client.AttachWithContext(ctx, cfg.pid, cfg.cmd, cfg.args...)
+
+	if cfg.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(&jattach.Result{PID: cfg.pid, Command: cfg.cmd, Args: cfg.args, Response: resp, Err: err})
+		if err != nil {
+			return 1
+		}
+		return resp.Code
+	}
+
+	if err != nil {
+		printError(cfg.pid, err)
+		return 1
+	}
+	return resp.Code
+}
+
+// printError prints an attach failure with the messages of the C jattach
+func printError(pid int, err error) {
+	switch {
+	case errors.Is(err, jattach.ErrProcessNotFound):
+		fmt.Fprintf(os.Stderr, "Process %d not found\n", pid)
+	case errors.Is(err, jattach.ErrPermissionDenied):
+		fmt.Fprintf(os.Stderr, "Failed to change credentials to match the target process: %v\n", err)
+	case errors.Is(err, jattach.ErrTimeout):
+		fmt.Fprintf(os.Stderr, "Timed out attaching to process %d: %v\n", pid, err)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// errUsage asks for the usage text
+var errUsage = errors.New("usage")
+
+// parseArgs parses the options, which come before the pid
+func parseArgs(args []string) (*config, error) {
+	// Like the C jattach, wait for the response as long as it takes
+	cfg := &config{readTime: -1}
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(opt, "-"), "=")
+		takeValue := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if len(args) == 0 {
+				return "", fmt.Errorf("option %s needs a value", opt)
+			}
+			v := args[0]
+			args = args[1:]
+			return v, nil
+		}
+
+		switch name {
+		case "timeout":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			cfg.timeout, err = parseTimeout(v)
+			if err != nil {
+				return nil, err
+			}
+		case "read-timeout":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			cfg.readTime, err = parseTimeout(v)
+			if err != nil {
+				return nil, err
+			}
+		case "tmp-path":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			cfg.tmpPath = v
+		case "proc-root":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			cfg.procRoot = v
+		case "namespaces":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			cfg.nsMode, err = parseNamespaceMode(v)
+			if err != nil {
+				return nil, err
+			}
+		case "reply-address":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			if net.ParseIP(v) == nil {
+				return nil, fmt.Errorf("illegal reply address %q", v)
+			}
+			cfg.replyIP = v
+		case "quiet", "q":
+			cfg.quiet = true
+		case "json":
+			cfg.json = true
+		case "help", "h":
+			return nil, errUsage
+		default:
+			return nil, fmt.Errorf("unknown option %s", opt)
+		}
+	}
+
+	if len(args) < 2 {
+		return nil, errUsage
+	}
+
+	pid, err := strconv.Atoi(args[0])
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("%s is not a valid process ID", args[0])
+	}
+	cfg.pid = pid
+	cfg.cmd = args[1]
+	cfg.args = args[2:]
+
+	return cfg, nil
+}
+
+// parseTimeout parses seconds or a duration with a unit
+func parseTimeout(s string) (time.Duration, error) {
+	if sec, err := strconv.Atoi(s); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("illegal timeout %q", s)
+	}
+	return d, nil
+}
+
+// parseNamespaceMode accepts the names printed by NamespaceMode.String
+func parseNamespaceMode(s string) (jattach.NamespaceMode, error) {
+	for _, mode := range []jattach.NamespaceMode{jattach.NamespaceAuto, jattach.NamespaceEnter, jattach.NamespaceNone} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("illegal namespace mode %q", s)
+}
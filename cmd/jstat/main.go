@@ -0,0 +1,153 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Command jstat prints jstat-style statistics of a HotSpot JVM from its
+// hsperfdata counters, for containers that ship no JDK tools
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xxs-2/jattach-go/jstat"
+)
+
+const usage = `Usage: jstat -<option> [-t] [-h<lines>] [--proc-root <path>] <pid> [<interval> [<count>]]
+
+Options:
+    -gcutil    Space utilization and collection statistics
+    -gc        Space sizes and collection statistics
+    -gccause   Like -gcutil, plus the last and current collection cause
+    -class     Class loader statistics
+    -compiler  JIT compiler statistics
+
+    -t         Show the JVM uptime as the first column
+    -h<lines>  Repeat the header every <lines> rows
+    --proc-root <path>
+               Mount point of the host procfs (or JATTACH_PROC_ROOT)
+
+<interval> is in milliseconds, or a duration with a unit such as 5s
+`
+
+// config holds the parsed command line
+type config struct {
+	view        jstat.View
+	timestamp   bool
+	headerEvery int
+	procRoot    string
+	pid         int
+	interval    time.Duration
+	count       int
+}
+
+func main() {
+	cfg, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sampler := jstat.NewSampler(cfg.pid, cfg.interval)
+	sampler.Count = cfg.count
+	sampler.ProcRoot = cfg.procRoot
+
+	rows := 0
+	for snapshot := range sampler.Start(ctx) {
+		if rows == 0 || (cfg.headerEvery > 0 && rows%cfg.headerEvery == 0) {
+			fmt.Println(cfg.view.Header(cfg.timestamp))
+		}
+		fmt.Println(cfg.view.Row(snapshot, cfg.timestamp))
+		rows++
+	}
+
+	if err := sampler.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%d not found: %v\n", cfg.pid, err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses the jstat command line
+func parseArgs(args []string) (*config, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing option")
+	}
+
+	cfg := &config{}
+	var err error
+	cfg.view, err = jstat.ParseView(args[0])
+	if err != nil {
+		return nil, err
+	}
+	args = args[1:]
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch opt := args[0]; {
+		case opt == "-t":
+			cfg.timestamp = true
+		case opt == "--proc-root":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("option %s needs a value", opt)
+			}
+			cfg.procRoot = args[1]
+			args = args[1:]
+		case strings.HasPrefix(opt, "-h"):
+			cfg.headerEvery, err = strconv.Atoi(opt[2:])
+			if err != nil || cfg.headerEvery <= 0 {
+				return nil, fmt.Errorf("illegal argument %q", opt)
+			}
+		default:
+			return nil, fmt.Errorf("illegal argument %q", opt)
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args) > 3 {
+		return nil, fmt.Errorf("wrong number of arguments")
+	}
+
+	cfg.pid, err = strconv.Atoi(args[0])
+	if err != nil || cfg.pid <= 0 {
+		return nil, fmt.Errorf("%s is not a valid process ID", args[0])
+	}
+
+	// Without an interval, print a single sample like jstat does
+	cfg.count = 1
+	if len(args) > 1 {
+		cfg.interval, err = parseInterval(args[1])
+		if err != nil {
+			return nil, err
+		}
+		cfg.count = 0
+	}
+	if len(args) > 2 {
+		cfg.count, err = strconv.Atoi(args[2])
+		if err != nil || cfg.count <= 0 {
+			return nil, fmt.Errorf("illegal count %q", args[2])
+		}
+	}
+
+	return cfg, nil
+}
+
+// parseInterval parses milliseconds or a duration with a unit
+func parseInterval(s string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(s); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("illegal interval %q", s)
+	}
+	return d, nil
+}
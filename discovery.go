@@ -0,0 +1,178 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xxs-2/jattach-go/hsperf"
+	"github.com/xxs-2/jattach-go/internal/process"
+	"github.com/xxs-2/jattach-go/internal/protocol"
+)
+
+// JVM describes an attachable JVM found by ListJVMs
+type JVM struct {
+	// PID is the process ID on the host
+	PID int
+
+	// NsPID is the process ID inside the container namespace (or PID)
+	NsPID int
+
+	// UID and GID are the effective credentials of the JVM process
+	UID uint32
+	GID uint32
+
+	// JVMType is the detected JVM implementation. JVMTypeUnknown means
+	// the process looks like a JVM but published no attach files
+	JVMType JVMType
+
+	// MainClass is the main class, module or jar, if it could be determined
+	MainClass string
+
+	// Listening reports whether the attach listener is already running,
+	// so attaching will not need to signal the JVM
+	Listening bool
+}
+
+// ListJVMs scans the host for attachable JVMs, including those running in
+// containers. HotSpot JVMs are recognized by their hsperfdata file and
+// OpenJ9 JVMs by their .com_ibm_tools_attach directory, both looked up in
+// the temporary directory as seen by the process (/proc/<pid>/root/tmp)
+// Processes are looked up under JATTACH_PROC_ROOT if set
+func ListJVMs(ctx context.Context) ([]JVM, error) {
+	return NewClient().ListJVMs(ctx)
+}
+
+// ListJVMs is like the ListJVMs function, with the processes looked up
+// under Options.ProcRoot
+func (c *Client) ListJVMs(ctx context.Context) ([]JVM, error) {
+	procRoot := c.options.ProcRoot
+	pids, err := process.ListPIDs(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var jvms []JVM
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if jvm, ok := inspectJVM(procRoot, pid); ok {
+			jvms = append(jvms, jvm)
+		}
+	}
+
+	sort.Slice(jvms, func(i, j int) bool { return jvms[i].PID < jvms[j].PID })
+	return jvms, nil
+}
+
+// inspectJVM checks whether pid, under procRoot, is a JVM and collects its
+// description
+func inspectJVM(procRoot string, pid int) (JVM, bool) {
+	info, err := process.GetProcessInfo(procRoot, pid)
+	if err != nil {
+		return JVM{}, false
+	}
+
+	tmpPath, err := process.GetTmpPath(procRoot, pid)
+	if err != nil {
+		return JVM{}, false
+	}
+
+	args, _ := process.Cmdline(procRoot, pid)
+
+	jvm := JVM{
+		PID:   pid,
+		NsPID: info.NsPID,
+		UID:   info.UID,
+		GID:   info.GID,
+	}
+
+	switch {
+	case protocol.IsOpenJ9Process(tmpPath, info.NsPID):
+		// OpenJ9 starts its attach listener together with the VM
+		jvm.JVMType = JVMTypeOpenJ9
+		jvm.Listening = true
+	case protocol.IsHotSpotListening(tmpPath, info.NsPID):
+		jvm.JVMType = JVMTypeHotSpot
+		jvm.Listening = true
+	case hasPerfData(tmpPath, info.NsPID):
+		jvm.JVMType = JVMTypeHotSpot
+	case len(args) > 0 && filepath.Base(args[0]) == "java":
+		jvm.JVMType = JVMTypeUnknown
+	default:
+		return JVM{}, false
+	}
+
+	if len(args) > 1 {
+		jvm.MainClass = mainClass(args[1:])
+	}
+	if jvm.MainClass == "" {
+		// The command line may be unreadable, fall back to what the JVM
+		// itself published
+		if path, err := process.FindPerfData(tmpPath, info.NsPID); err == nil {
+			if pd, err := hsperf.ReadFile(path); err == nil {
+				if fields := strings.Fields(pd.JavaCommand()); len(fields) > 0 {
+					jvm.MainClass = fields[0]
+				}
+			}
+		}
+	}
+
+	return jvm, true
+}
+
+// hasPerfData checks if the HotSpot JVM published its hsperfdata file
+func hasPerfData(tmpPath string, nspid int) bool {
+	_, err := process.FindPerfData(tmpPath, nspid)
+	return err == nil
+}
+
+// launcherOptionsWithValue are java launcher options whose value is the
+// next argument
+var launcherOptionsWithValue = map[string]bool{
+	"-cp":                    true,
+	"-classpath":             true,
+	"--class-path":           true,
+	"-p":                     true,
+	"--module-path":          true,
+	"--upgrade-module-path":  true,
+	"--add-modules":          true,
+	"--limit-modules":        true,
+	"--add-reads":            true,
+	"--add-exports":          true,
+	"--add-opens":            true,
+	"--patch-module":         true,
+	"--enable-native-access": true,
+	"--source":               true,
+}
+
+// mainClass extracts the main class, module or jar from java launcher
+// arguments (without the executable itself)
+func mainClass(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-jar" || arg == "-m" || arg == "--module":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		case strings.HasPrefix(arg, "--module="):
+			return strings.TrimPrefix(arg, "--module=")
+		case launcherOptionsWithValue[arg]:
+			i++
+		case strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "@"):
+			// Other options and argument files
+		default:
+			return arg
+		}
+	}
+	return ""
+}
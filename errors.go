@@ -8,6 +8,9 @@ package jattach
 import (
 	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/xxs-2/jattach-go/internal/protocol"
 )
 
 var (
@@ -31,6 +34,27 @@ var (
 
 	// ErrAgentLoadFailed indicates agent loading failed
 	ErrAgentLoadFailed = errors.New("agent load failed")
+
+	// ErrCommandFailed indicates the JVM returned a non-zero code
+	ErrCommandFailed = errors.New("command failed")
+
+	// ErrSessionClosed indicates a Session was used after Close or after a
+	// failed command
+	ErrSessionClosed = errors.New("session closed")
+
+	// ErrReduceSignalUsage indicates a JVM that does not handle SIGQUIT,
+	// because of -Xrs (-XX:+ReduceSignalUsage) or because it is still
+	// starting. Signaling it would kill it instead of starting the attach
+	// listener
+	ErrReduceSignalUsage = errors.New("JVM does not handle SIGQUIT")
+
+	// ErrUntrusted indicates an attach endpoint that failed a security
+	// check, see SecurityError
+	ErrUntrusted = errors.New("untrusted attach endpoint")
+
+	// ErrNamespaceRequired indicates the target can only be attached to
+	// from inside its namespaces, which could not or may not be entered
+	ErrNamespaceRequired = errors.New("namespace switch required")
 )
 
 // AttachError wraps errors with context about the attach operation
@@ -48,6 +72,22 @@ func (e *AttachError) Unwrap() error {
 	return e.Err
 }
 
+// SecurityError reports an attach endpoint that may not belong to the
+// target JVM, such as a .java_pid socket owned by another user or served by
+// another process. Nothing is sent to it
+type SecurityError struct {
+	Path   string // The socket or file that was refused
+	Reason string
+}
+
+func (e *SecurityError) Error() string {
+	return fmt.Sprintf("refusing %s: %s", e.Path, e.Reason)
+}
+
+func (e *SecurityError) Unwrap() error {
+	return ErrUntrusted
+}
+
 // wrapError creates a wrapped error with context
 func wrapError(op string, pid int, err error) error {
 	if err == nil {
@@ -55,3 +95,32 @@ func wrapError(op string, pid int, err error) error {
 	}
 	return &AttachError{Op: op, PID: pid, Err: err}
 }
+
+// commandError reports a non-zero return code of cmd with the JVM's message
+func commandError(cmd string, pid int, code int, output string) error {
+	return wrapError(cmd, pid, fmt.Errorf("%w: code %d: %s", ErrCommandFailed, code, strings.TrimSpace(output)))
+}
+
+// attachError wraps an error from the protocol handlers, reporting
+// timeouts as ErrTimeout with the phase that timed out as the operation
+func attachError(pid int, err error) error {
+	var timeoutErr *protocol.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return wrapError(timeoutErr.Phase, pid, ErrTimeout)
+	}
+	var securityErr *protocol.SecurityError
+	if errors.As(err, &securityErr) {
+		return wrapError("connect", pid, &SecurityError{Path: securityErr.Path, Reason: securityErr.Reason})
+	}
+	switch {
+	case errors.Is(err, protocol.ErrProcessGone):
+		return wrapError("signal", pid, ErrProcessNotFound)
+	case errors.Is(err, protocol.ErrForeignPID):
+		return wrapError("signal", pid, fmt.Errorf("%w: %w", ErrNamespaceRequired, protocol.ErrForeignPID))
+	case errors.Is(err, protocol.ErrNotJVM):
+		return wrapError("check_jvm", pid, ErrNotJavaProcess)
+	case errors.Is(err, protocol.ErrQuitNotHandled):
+		return wrapError("check_jvm", pid, ErrReduceSignalUsage)
+	}
+	return wrapError("attach", pid, err)
+}
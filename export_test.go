@@ -0,0 +1,12 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+// Parsers exported for the tests of package jattach_test
+var (
+	ParseFlags       = parseFlags
+	ParseOpenJ9Flags = parseOpenJ9Flags
+)
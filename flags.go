@@ -0,0 +1,220 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrUnknownFlag indicates the JVM has no flag with the given name
+	ErrUnknownFlag = errors.New("unknown flag")
+
+	// ErrFlagNotManageable indicates the flag cannot be changed at run time
+	ErrFlagNotManageable = errors.New("flag is not manageable")
+)
+
+// FlagOrigin tells where the value of a VM flag comes from
+type FlagOrigin string
+
+const (
+	// OriginDefault is the built-in default value
+	OriginDefault FlagOrigin = "default"
+
+	// OriginCommandLine is a -XX option of the command line
+	OriginCommandLine FlagOrigin = "command line"
+
+	// OriginEnvironment is a -XX option of JAVA_TOOL_OPTIONS or similar
+	OriginEnvironment FlagOrigin = "environment"
+
+	// OriginConfigFile is a -XX:Flags= file
+	OriginConfigFile FlagOrigin = "config file"
+
+	// OriginErgonomic is a value chosen by the JVM for the host
+	OriginErgonomic FlagOrigin = "ergonomic"
+
+	// OriginManagement is a value set at run time, e.g. by SetFlag
+	OriginManagement FlagOrigin = "management"
+
+	// OriginAttach is a value set by an agent loaded at run time
+	OriginAttach FlagOrigin = "attach"
+
+	// OriginNonDefault is reported by JDK 8, which only marks the flags
+	// that are not at their default value
+	OriginNonDefault FlagOrigin = "non-default"
+)
+
+// Flag is a VM flag (-XX option) of a running JVM
+type Flag struct {
+	Name string `json:"name"`
+
+	// Type is the HotSpot type, e.g. "bool", "intx", "size_t" or "ccstr".
+	// It is empty when the JVM does not report it
+	Type string `json:"type,omitempty"`
+
+	Value string `json:"value"`
+
+	// Kind is the HotSpot flag kind, e.g. "product", "manageable" or
+	// "diagnostic"
+	Kind string `json:"kind,omitempty"`
+
+	Origin FlagOrigin `json:"origin,omitempty"`
+
+	// Manageable flags can be changed at run time with SetFlag
+	Manageable bool `json:"manageable"`
+}
+
+// FlagError reports a flag that SetFlag refused to change
+type FlagError struct {
+	Name string
+
+	// Flag is the current state of the flag, nil if it does not exist
+	Flag *Flag
+}
+
+func (e *FlagError) Error() string {
+	if e.Flag == nil {
+		return fmt.Sprintf("unknown flag %s", e.Name)
+	}
+	return fmt.Sprintf("flag %s is not manageable (kind %s, origin %s)", e.Name, e.Flag.Kind, e.Flag.Origin)
+}
+
+func (e *FlagError) Unwrap() error {
+	if e.Flag == nil {
+		return ErrUnknownFlag
+	}
+	return ErrFlagNotManageable
+}
+
+// Flags returns the VM flags of the JVM
+// On HotSpot this is every flag, from jcmd VM.flags -all. OpenJ9 has no
+// such inventory, so only the -XX options of its command line are returned,
+// none of them manageable
+func (c *Client) Flags(ctx context.Context, pid int) ([]Flag, error) {
+	if c.jvmType(pid) == JVMTypeOpenJ9 {
+		return c.openJ9Flags(ctx, pid)
+	}
+
+	// jcmd takes the whole command line as a single argument
+	output, jvmType, err := c.command(ctx, pid, CmdJCmd, "VM.flags -all")
+	if err != nil {
+		return nil, err
+	}
+	if jvmType == JVMTypeOpenJ9 {
+		// The attach files were out of reach before the attach
+		return c.openJ9Flags(ctx, pid)
+	}
+
+	flags := parseFlags(output)
+	if len(flags) == 0 {
+		return nil, commandError(CmdJCmd, pid, 0, output)
+	}
+	return flags, nil
+}
+
+// openJ9Flags returns the -XX options of the command line of an OpenJ9 JVM
+func (c *Client) openJ9Flags(ctx context.Context, pid int) ([]Flag, error) {
+	props, err := c.AgentProperties(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+	return parseOpenJ9Flags(props["sun.jvm.args"]), nil
+}
+
+// flagLine matches a line of VM.flags -all (PrintFlagsFinal format)
+// JDK 9+:     bool HeapDumpOnOutOfMemoryError  = false  {manageable} {default}
+// JDK 8:     uintx MaxHeapSize                := 268435456  {product}
+var flagLine = regexp.MustCompile(`^\s*(\S+)\s+(\w+)\s+(:?=)\s?(.*?)\s*\{([^}]*)\}(?:\s*\{([^}]*)\})?\s*$`)
+
+// parseFlags parses the output of VM.flags -all
+func parseFlags(output string) []Flag {
+	var flags []Flag
+	for _, line := range strings.Split(output, "\n") {
+		m := flagLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		f := Flag{
+			Name:  m[2],
+			Type:  m[1],
+			Value: strings.TrimSpace(m[4]),
+			Kind:  m[5],
+		}
+		f.Manageable = strings.Contains(f.Kind, "manageable")
+
+		switch {
+		case m[6] != "":
+			// "command line, ergonomic" means ergonomics adjusted a value
+			// that was set on the command line
+			origin := m[6]
+			if idx := strings.LastIndex(origin, ", "); idx != -1 {
+				origin = origin[idx+2:]
+			}
+			f.Origin = FlagOrigin(origin)
+		case m[3] == ":=":
+			f.Origin = OriginNonDefault
+		default:
+			f.Origin = OriginDefault
+		}
+
+		flags = append(flags, f)
+	}
+	return flags
+}
+
+// parseOpenJ9Flags extracts the -XX options from the JVM arguments
+func parseOpenJ9Flags(args string) []Flag {
+	flags := []Flag{}
+	for _, arg := range strings.Fields(args) {
+		opt, ok := strings.CutPrefix(arg, "-XX:")
+		if !ok || opt == "" {
+			continue
+		}
+
+		f := Flag{Origin: OriginCommandLine}
+		switch {
+		case opt[0] == '+' || opt[0] == '-':
+			f.Name = opt[1:]
+			f.Type = "bool"
+			f.Value = fmt.Sprint(opt[0] == '+')
+		case strings.Contains(opt, "="):
+			f.Name, f.Value, _ = strings.Cut(opt, "=")
+		default:
+			f.Name = opt
+		}
+		flags = append(flags, f)
+	}
+	return flags
+}
+
+// checkFlag makes sure the flag exists and is manageable before SetFlag
+// sends the new value. If the inventory cannot be taken, the JVM is left
+// to decide
+func (c *Client) checkFlag(ctx context.Context, pid int, name string) error {
+	flags, err := c.Flags(ctx, pid)
+	if errors.Is(err, ErrCommandFailed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range flags {
+		if flags[i].Name != name {
+			continue
+		}
+		if !flags[i].Manageable {
+			return wrapError(CmdSetFlag, pid, &FlagError{Name: name, Flag: &flags[i]})
+		}
+		return nil
+	}
+	return wrapError(CmdSetFlag, pid, &FlagError{Name: name})
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+// findFlag returns the named flag, or nil
+func findFlag(flags []jattach.Flag, name string) *jattach.Flag {
+	for i := range flags {
+		if flags[i].Name == name {
+			return &flags[i]
+		}
+	}
+	return nil
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []jattach.Flag
+	}{
+		{"JDK 8", `     bool HeapDumpOnOutOfMemoryError                = false                               {manageable}
+    uintx MaxHeapSize                              := 4164943872                          {product}
+     bool PrintConcurrentLocks                      = false                               {manageable}
+     bool UseG1GC                                   = false                               {product}
+     bool UseParallelGC                            := true                                {product}
+`, []jattach.Flag{
+			{Name: "MaxHeapSize", Type: "uintx", Value: "4164943872", Kind: "product", Origin: jattach.OriginNonDefault},
+			{Name: "PrintConcurrentLocks", Type: "bool", Value: "false", Kind: "manageable", Origin: jattach.OriginDefault, Manageable: true},
+		}},
+		{"JDK 17", `     bool HeapDumpOnOutOfMemoryError                = false                                  {manageable} {default}
+   size_t MaxHeapSize                              = 4164943872                             {product} {ergonomic}
+     bool PrintConcurrentLocks                     = false                                  {manageable} {default}
+     bool UseG1GC                                  = true                                   {product} {ergonomic}
+    ccstr HeapDumpPath                             = /var/dumps                             {manageable} {command line}
+     intx CICompilerCount                          = 4                                      {product} {command line, ergonomic}
+`, []jattach.Flag{
+			{Name: "MaxHeapSize", Type: "size_t", Value: "4164943872", Kind: "product", Origin: jattach.OriginErgonomic},
+			{Name: "HeapDumpOnOutOfMemoryError", Type: "bool", Value: "false", Kind: "manageable", Origin: jattach.OriginDefault, Manageable: true},
+			{Name: "HeapDumpPath", Type: "ccstr", Value: "/var/dumps", Kind: "manageable", Origin: jattach.OriginCommandLine, Manageable: true},
+			{Name: "CICompilerCount", Type: "intx", Value: "4", Kind: "product", Origin: jattach.OriginErgonomic},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := jattach.ParseFlags(tt.output)
+			if len(flags) < 5 {
+				t.Errorf("got %d flags, want at least 5", len(flags))
+			}
+			for _, want := range tt.want {
+				if got := findFlag(flags, want.Name); got == nil || *got != want {
+					t.Errorf("flag %s = %+v, want %+v", want.Name, got, want)
+				}
+			}
+		})
+	}
+
+	if flags := jattach.ParseFlags("java.lang.IllegalArgumentException: Unknown argument\n"); len(flags) != 0 {
+		t.Errorf("flags of an error message = %+v", flags)
+	}
+}
+
+func TestParseOpenJ9Flags(t *testing.T) {
+	flags := jattach.ParseOpenJ9Flags("-Xmx1g -XX:+HeapDumpOnOutOfMemoryError -XX:-UseCompressedOops -XX:MaxDirectMemorySize=64m -XX: -Dx=1")
+	want := []jattach.Flag{
+		{Name: "HeapDumpOnOutOfMemoryError", Type: "bool", Value: "true", Origin: jattach.OriginCommandLine},
+		{Name: "UseCompressedOops", Type: "bool", Value: "false", Origin: jattach.OriginCommandLine},
+		{Name: "MaxDirectMemorySize", Value: "64m", Origin: jattach.OriginCommandLine},
+	}
+	if len(flags) != len(want) {
+		t.Fatalf("flags = %+v, want %+v", flags, want)
+	}
+	for i := range want {
+		if flags[i] != want[i] {
+			t.Errorf("flag %d = %+v, want %+v", i, flags[i], want[i])
+		}
+	}
+}
+
+func TestFlagError(t *testing.T) {
+	err := error(&jattach.FlagError{Name: "UseG1GC", Flag: &jattach.Flag{Name: "UseG1GC", Kind: "product", Origin: jattach.OriginErgonomic}})
+	if !errors.Is(err, jattach.ErrFlagNotManageable) || errors.Is(err, jattach.ErrUnknownFlag) {
+		t.Errorf("not manageable: %v", err)
+	}
+	err = &jattach.FlagError{Name: "NoSuchFlag"}
+	if !errors.Is(err, jattach.ErrUnknownFlag) {
+		t.Errorf("unknown: %v", err)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		script jattachtest.Script
+		want   []jattach.Flag
+	}{
+		{"HotSpot 8", jattachtest.HotSpot8(), []jattach.Flag{
+			{Name: "MaxHeapSize", Type: "uintx", Value: "4164943872", Kind: "product", Origin: jattach.OriginNonDefault},
+			{Name: "PrintConcurrentLocks", Type: "bool", Value: "false", Kind: "manageable", Origin: jattach.OriginDefault, Manageable: true},
+		}},
+		{"HotSpot 17", jattachtest.HotSpot17(), []jattach.Flag{
+			{Name: "MaxHeapSize", Type: "size_t", Value: "4164943872", Kind: "product", Origin: jattach.OriginErgonomic},
+			{Name: "UseG1GC", Type: "bool", Value: "true", Kind: "product", Origin: jattach.OriginErgonomic},
+			{Name: "HeapDumpOnOutOfMemoryError", Type: "bool", Value: "false", Kind: "manageable", Origin: jattach.OriginDefault, Manageable: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, jvm := newHotSpot(t, tt.script)
+
+			flags, err := client.Flags(context.Background(), jvm.PID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(flags) != 5 {
+				t.Errorf("got %d flags, want 5", len(flags))
+			}
+			if got := commandKeys(jvm); got != "jcmd VM.flags" {
+				t.Errorf("commands = %s", got)
+			}
+			for _, want := range tt.want {
+				if got := findFlag(flags, want.Name); got == nil || *got != want {
+					t.Errorf("flag %s = %+v, want %+v", want.Name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFlagsOpenJ9(t *testing.T) {
+	client, jvm := newOpenJ9(t, jattachtest.OpenJ9())
+
+	flags, err := client.Flags(context.Background(), jvm.PID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := jattach.Flag{Name: "HeapDumpOnOutOfMemoryError", Type: "bool", Value: "true", Origin: jattach.OriginCommandLine}
+	if len(flags) != 1 || flags[0] != want {
+		t.Errorf("flags = %+v, want %+v", flags, want)
+	}
+	// OpenJ9 has no VM.flags, which is not even tried
+	if got := commandKeys(jvm); got != "agentProperties" {
+		t.Errorf("commands = %s", got)
+	}
+}
+
+func TestFlagsCommandFailed(t *testing.T) {
+	script := jattachtest.HotSpot17()
+	script["jcmd VM.flags"] = jattachtest.Reply{Code: 1, Output: "java.lang.IllegalArgumentException: Unknown argument\n"}
+	client, jvm := newHotSpot(t, script)
+
+	_, err := client.Flags(context.Background(), jvm.PID())
+	if !errors.Is(err, jattach.ErrCommandFailed) {
+		t.Errorf("got %v, want ErrCommandFailed", err)
+	}
+}
@@ -0,0 +1,399 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// helperEnv marks a process started as a privilege-separated attach helper
+// Its value is "<request pipe inode>:<reply pipe inode>:<token>": only the
+// process started with those pipes as fd 3 and 4 is the helper, so a value
+// inherited by other processes is ignored, and the request must carry the
+// same token
+const helperEnv = "JATTACH_HELPER"
+
+// The helper reads its request from fd 3 and writes its reply to fd 4
+const (
+	helperRequestFD = 3
+	helperReplyFD   = 4
+)
+
+// sentinelErrors are the errors that keep their identity across the
+// helper pipe, so errors.Is works the same way in helper mode
+var sentinelErrors = []error{
+	ErrProcessNotFound,
+	ErrPermissionDenied,
+	ErrNotJavaProcess,
+	ErrConnectionFailed,
+	ErrTimeout,
+	ErrBitnessMatch,
+	ErrAgentLoadFailed,
+	ErrCommandFailed,
+	ErrReduceSignalUsage,
+	ErrUntrusted,
+	ErrNamespaceRequired,
+	ErrUnknownFlag,
+	ErrFlagNotManageable,
+}
+
+// helperRequest is sent from the parent to the helper
+type helperRequest struct {
+	Token       string        `json:"token"`
+	PID         int           `json:"pid"`
+	Cmd         string        `json:"cmd"`
+	Args        []string      `json:"args"`
+	Stream      bool          `json:"stream"`
+	PrintOutput bool          `json:"print_output"`
+	TmpPath     string        `json:"tmp_path"`
+	ProcRoot    string        `json:"proc_root,omitempty"`
+	Namespaces  NamespaceMode `json:"namespaces,omitempty"`
+	ReplyAddr   string        `json:"reply_address,omitempty"`
+	Timeout     time.Duration `json:"timeout"`
+	ReadTimeout time.Duration `json:"read_timeout"`
+	Deadline    time.Time     `json:"deadline"`
+	Log         bool          `json:"log"`
+}
+
+// helperMessage is one line of the helper reply header. Log lines may
+// precede the final line, which carries the result. The response body
+// follows the final line verbatim
+type helperMessage struct {
+	Log     string   `json:"log,omitempty"`
+	Done    bool     `json:"done,omitempty"`
+	Code    int      `json:"code"`
+	JVMType JVMType  `json:"jvm_type"`
+	NsPID   int      `json:"nspid,omitempty"`
+	Timings []Timing `json:"timings,omitempty"`
+	ErrOp   string   `json:"err_op,omitempty"`
+	ErrKind string   `json:"err_kind,omitempty"`
+	ErrMsg  string   `json:"err_msg,omitempty"`
+}
+
+func init() {
+	value, ok := os.LookupEnv(helperEnv)
+	if !ok {
+		return
+	}
+	// Neither the helper nor the program that inherited the variable
+	// passes it on
+	os.Unsetenv(helperEnv)
+
+	token, ok := helperToken(value, helperRequestFD, helperReplyFD)
+	if !ok {
+		return
+	}
+	os.Exit(runHelper(token))
+}
+
+// helperToken checks that reqFD and replyFD are the pipes described by the
+// helperEnv value, and returns its token
+func helperToken(value string, reqFD, replyFD int) (string, bool) {
+	fields := strings.SplitN(value, ":", 3)
+	if len(fields) != 3 || fields[2] == "" {
+		return "", false
+	}
+	for i, fd := range []int{reqFD, replyFD} {
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+			return "", false
+		}
+		if strconv.FormatUint(uint64(st.Ino), 10) != fields[i] {
+			return "", false
+		}
+	}
+	return fields[2], true
+}
+
+// pipeInode returns the inode number of a pipe end
+func pipeInode(f *os.File) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Ino), nil
+}
+
+// runHelper serves a single attach request in the helper process
+// It is free to switch namespaces and credentials, since the process
+// exits right after
+func runHelper(token string) int {
+	in := os.NewFile(helperRequestFD, "request")
+	out := os.NewFile(helperReplyFD, "reply")
+	if in == nil || out == nil {
+		fmt.Fprintln(os.Stderr, "jattach: helper started without its pipes")
+		return 1
+	}
+	defer out.Close()
+
+	var req helperRequest
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		fmt.Fprintf(os.Stderr, "jattach: helper could not read request: %v\n", err)
+		return 1
+	}
+	in.Close()
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+		fmt.Fprintln(os.Stderr, "jattach: helper request with a wrong token")
+		return 1
+	}
+
+	enc := json.NewEncoder(out)
+	opts := &Options{
+		PrintOutput:   req.PrintOutput,
+		TmpPath:       req.TmpPath,
+		ProcRoot:      req.ProcRoot,
+		NamespaceMode: req.Namespaces,
+		ReplyAddress:  req.ReplyAddr,
+		Timeout:       req.Timeout,
+		ReadTimeout:   req.ReadTimeout,
+	}
+	if req.Log {
+		opts.Logger = helperLogger{enc}
+	}
+	client := NewClientWithOptions(opts)
+
+	ctx := context.Background()
+	if !req.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, req.Deadline)
+		defer cancel()
+	}
+
+	if req.Stream {
+		stream, err := client.AttachStream(ctx, req.PID, req.Cmd, req.Args...)
+		if err != nil {
+			enc.Encode(helperFailure(err))
+			return 0
+		}
+		defer stream.Close()
+		enc.Encode(&helperMessage{Done: true, Code: stream.Code, JVMType: stream.JVMType})
+		io.Copy(out, stream)
+		return 0
+	}
+
+	resp, err := client.AttachWithContext(ctx, req.PID, req.Cmd, req.Args...)
+	if err != nil {
+		enc.Encode(helperFailure(err))
+		return 0
+	}
+	enc.Encode(&helperMessage{Done: true, Code: resp.Code, JVMType: resp.JVMType, NsPID: resp.NsPID, Timings: resp.Timings})
+	io.WriteString(out, resp.Output)
+	return 0
+}
+
+// helperFailure encodes an attach error for the parent
+func helperFailure(err error) *helperMessage {
+	msg := &helperMessage{Done: true, ErrOp: "attach", ErrMsg: err.Error()}
+	var attachErr *AttachError
+	if errors.As(err, &attachErr) {
+		msg.ErrOp = attachErr.Op
+		msg.ErrMsg = attachErr.Err.Error()
+	}
+	msg.ErrKind = errorKind(err)
+	return msg
+}
+
+// errorKind returns the message of the sentinel error err matches, or ""
+func errorKind(err error) string {
+	for _, sentinel := range sentinelErrors {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return ""
+}
+
+// helperLogger forwards diagnostic output to the parent's Logger
+type helperLogger struct {
+	enc *json.Encoder
+}
+
+func (l helperLogger) Printf(format string, v ...interface{}) {
+	l.enc.Encode(&helperMessage{Log: fmt.Sprintf(format, v...)})
+}
+
+// helperStream is the reply body of a running helper
+type helperStream struct {
+	io.Reader
+	pipe *os.File
+	cmd  *exec.Cmd
+}
+
+func (s *helperStream) Close() error {
+	s.pipe.Close()
+	return s.cmd.Wait()
+}
+
+// errHelperSignalHook is returned instead of starting a helper for a client
+// with a SignalHook
+var errHelperSignalHook = errors.New("a SignalHook cannot be used with a helper process")
+
+// attachHelper runs an attach request in a re-executed copy of the current
+// binary. It returns the reply header and the response body, which the
+// caller must close
+func (c *Client) attachHelper(ctx context.Context, pid int, cmd string, args []string, stream bool) (*helperMessage, io.ReadCloser, error) {
+	if c.options.SignalHook != nil {
+		// The helper would send a real signal
+		return nil, nil, wrapError("start_helper", pid, errHelperSignalHook)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, wrapError("start_helper", pid, err)
+	}
+
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, wrapError("start_helper", pid, err)
+	}
+	defer reqR.Close()
+	defer reqW.Close()
+
+	replyR, replyW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, wrapError("start_helper", pid, err)
+	}
+	defer replyW.Close()
+
+	env, token, err := helperEnvValue(reqR, replyW)
+	if err != nil {
+		replyR.Close()
+		return nil, nil, wrapError("start_helper", pid, err)
+	}
+
+	helper := exec.CommandContext(ctx, exe)
+	helper.Env = append(os.Environ(), helperEnv+"="+env)
+	helper.Stdout = os.Stdout
+	helper.Stderr = os.Stderr
+	helper.ExtraFiles = []*os.File{reqR, replyW}
+	if err := helper.Start(); err != nil {
+		replyR.Close()
+		return nil, nil, wrapError("start_helper", pid, err)
+	}
+	reqR.Close()
+	replyW.Close()
+
+	req := &helperRequest{
+		Token:       token,
+		PID:         pid,
+		Cmd:         cmd,
+		Args:        args,
+		Stream:      stream,
+		PrintOutput: c.options.PrintOutput && !stream,
+		TmpPath:     c.options.TmpPath,
+		ProcRoot:    c.options.ProcRoot,
+		Namespaces:  c.options.NamespaceMode,
+		ReplyAddr:   c.options.ReplyAddress,
+		Timeout:     c.options.Timeout,
+		ReadTimeout: c.options.ReadTimeout,
+		Log:         c.options.Logger != nil,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Deadline = deadline
+	}
+	json.NewEncoder(reqW).Encode(req)
+	reqW.Close()
+
+	reader := bufio.NewReader(replyR)
+	body := &helperStream{Reader: reader, pipe: replyR, cmd: helper}
+	msg, err := readHelperHeader(reader, c.options.Logger)
+	if err != nil {
+		body.Close()
+		if ctx.Err() != nil {
+			return nil, nil, wrapError("helper", pid, ctx.Err())
+		}
+		return nil, nil, wrapError("helper", pid, err)
+	}
+
+	if msg.ErrOp != "" {
+		body.Close()
+		return nil, nil, helperError(pid, msg)
+	}
+
+	return msg, body, nil
+}
+
+// helperEnvValue returns the helperEnv value for a helper reading its
+// request from reqR and writing its reply to replyW, and its new token
+func helperEnvValue(reqR, replyW *os.File) (string, string, error) {
+	reqIno, err := pipeInode(reqR)
+	if err != nil {
+		return "", "", err
+	}
+	replyIno, err := pipeInode(replyW)
+	if err != nil {
+		return "", "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return fmt.Sprintf("%d:%d:%s", reqIno, replyIno, token), token, nil
+}
+
+// readHelperHeader reads the helper reply up to the final header line,
+// forwarding log lines to logger
+func readHelperHeader(r *bufio.Reader, logger Logger) (*helperMessage, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("helper exited without a reply")
+		}
+
+		var msg helperMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("malformed helper reply: %w", err)
+		}
+		if msg.Done {
+			return &msg, nil
+		}
+		if logger != nil && msg.Log != "" {
+			logger.Printf("%s", msg.Log)
+		}
+	}
+}
+
+// helperError rebuilds the error reported by the helper
+func helperError(pid int, msg *helperMessage) error {
+	err := &remoteError{msg: msg.ErrMsg}
+	for _, sentinel := range sentinelErrors {
+		if msg.ErrKind == sentinel.Error() {
+			err.kind = sentinel
+			break
+		}
+	}
+	return wrapError(msg.ErrOp, pid, err)
+}
+
+// remoteError is an error reported by the helper. It matches the sentinel
+// error the helper classified it as
+type remoteError struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.kind
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestHelperToken(t *testing.T) {
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqR.Close()
+	defer reqW.Close()
+	replyR, replyW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replyR.Close()
+	defer replyW.Close()
+
+	env, token, err := helperEnvValue(reqR, replyW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqFD, replyFD := int(reqR.Fd()), int(replyW.Fd())
+	if got, ok := helperToken(env, reqFD, replyFD); !ok || got != token {
+		t.Fatalf("helperToken(%q) = %q, %v, want %q", env, got, ok, token)
+	}
+
+	otherR, otherW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherR.Close()
+	defer otherW.Close()
+	file, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// A value inherited by another process, whose fds are other pipes or
+	// files, does not make it a helper
+	reqIno, _ := pipeInode(reqR)
+	replyIno, _ := pipeInode(replyW)
+	tests := []struct {
+		name           string
+		value          string
+		reqFD, replyFD int
+	}{
+		{"legacy value", "1", reqFD, replyFD},
+		{"no token", fmt.Sprintf("%d:%d:", reqIno, replyIno), reqFD, replyFD},
+		{"other pipes", env, int(otherR.Fd()), int(otherW.Fd())},
+		{"swapped pipes", env, replyFD, reqFD},
+		{"regular file", env, int(file.Fd()), replyFD},
+		{"closed fd", env, 1 << 20, replyFD},
+	}
+	for _, tt := range tests {
+		if _, ok := helperToken(tt.value, tt.reqFD, tt.replyFD); ok {
+			t.Errorf("%s: accepted as a helper", tt.name)
+		}
+	}
+}
+
+func TestSwitchCredentialsDropsGroups(t *testing.T) {
+	if os.Getenv("JATTACH_TEST_SWITCH_CREDENTIALS") == "1" {
+		// In the child: switch to nobody and report the groups left
+		if err := switchCredentials(0, 65534, 65534); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		status, err := os.ReadFile("/proc/self/status")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, line := range strings.Split(string(status), "\n") {
+			if strings.HasPrefix(line, "Groups:") {
+				fmt.Println(line)
+			}
+		}
+		os.Exit(0)
+	}
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("switching credentials needs root on Linux")
+	}
+
+	// The credentials of the process cannot be restored, so the switch
+	// runs in a child with supplementary groups to drop
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSwitchCredentialsDropsGroups$")
+	cmd.Env = append(os.Environ(), "JATTACH_TEST_SWITCH_CREDENTIALS=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Groups: []uint32{4, 27}}}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "Groups:" {
+		t.Errorf("groups after the switch = %q, want none", got)
+	}
+}
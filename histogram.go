@@ -0,0 +1,36 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+	"time"
+
+	"github.com/xxs-2/jattach-go/histogram"
+)
+
+// ClassHistogram runs inspectheap and parses the class histogram of live
+// objects. Both HotSpot and OpenJ9 run a full GC first
+func (c *Client) ClassHistogram(ctx context.Context, pid int) (*histogram.Histogram, error) {
+	output, _, err := c.command(ctx, pid, CmdInspectHeap)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := histogram.ParseString(output)
+	if err != nil {
+		return nil, wrapError(CmdInspectHeap, pid, err)
+	}
+	return h, nil
+}
+
+// ClassHistograms takes count class histograms, interval apart, for
+// histogram.Growing to find classes with steadily growing instance counts
+func (c *Client) ClassHistograms(ctx context.Context, pid int, count int, interval time.Duration) ([]*histogram.Histogram, error) {
+	return histogram.Collect(ctx, count, interval, func(ctx context.Context) (*histogram.Histogram, error) {
+		return c.ClassHistogram(ctx, pid)
+	})
+}
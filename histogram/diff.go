@@ -0,0 +1,153 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package histogram
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Delta is the change of one class between two histograms
+type Delta struct {
+	Class  string `json:"class"`
+	Module string `json:"module,omitempty"`
+
+	// Instances and Bytes are the growth from the first histogram to the
+	// second, negative if the class shrank
+	Instances int64 `json:"instances"`
+	Bytes     int64 `json:"bytes"`
+
+	// Before and After are the entries in each histogram. They are zero
+	// if the class is missing from it
+	Before Entry `json:"before"`
+	After  Entry `json:"after"`
+}
+
+// Diff compares two histograms of the same JVM, a taken before b
+// The result is ranked by growth in bytes, then in instances
+func Diff(a, b *Histogram) []Delta {
+	before, after := a.merged(), b.merged()
+
+	deltas := make([]Delta, 0, len(after))
+	for k, e := range after {
+		prev := before[k]
+		deltas = append(deltas, Delta{
+			Class:     k.class,
+			Module:    k.module,
+			Instances: e.Instances - prev.Instances,
+			Bytes:     e.Bytes - prev.Bytes,
+			Before:    prev,
+			After:     e,
+		})
+	}
+	for k, prev := range before {
+		if _, ok := after[k]; ok {
+			continue
+		}
+		deltas = append(deltas, Delta{
+			Class:     k.class,
+			Module:    k.module,
+			Instances: -prev.Instances,
+			Bytes:     -prev.Bytes,
+			Before:    prev,
+		})
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].Bytes != deltas[j].Bytes {
+			return deltas[i].Bytes > deltas[j].Bytes
+		}
+		if deltas[i].Instances != deltas[j].Instances {
+			return deltas[i].Instances > deltas[j].Instances
+		}
+		return deltas[i].Class < deltas[j].Class
+	})
+	return deltas
+}
+
+// Trend is the history of one class over a series of histograms
+type Trend struct {
+	Class  string `json:"class"`
+	Module string `json:"module,omitempty"`
+
+	// Instances and Bytes hold one value per histogram
+	Instances []int64 `json:"instances"`
+	Bytes     []int64 `json:"bytes"`
+}
+
+// Growth returns the instance count increase from the first histogram to
+// the last
+func (t *Trend) Growth() int64 {
+	return t.Instances[len(t.Instances)-1] - t.Instances[0]
+}
+
+// Growing returns the classes whose instance count never went down over
+// the histograms and grew overall, the usual signature of a leak
+// The histograms must be in the order they were taken; the result is
+// ranked by instance growth
+func Growing(histograms []*Histogram) []Trend {
+	if len(histograms) < 2 {
+		return nil
+	}
+
+	samples := make([]map[key]Entry, len(histograms))
+	for i, h := range histograms {
+		samples[i] = h.merged()
+	}
+
+	var trends []Trend
+	for k := range samples[len(samples)-1] {
+		t := Trend{Class: k.class, Module: k.module}
+		steady := true
+		for i, s := range samples {
+			e := s[k]
+			if i > 0 && e.Instances < t.Instances[i-1] {
+				steady = false
+				break
+			}
+			t.Instances = append(t.Instances, e.Instances)
+			t.Bytes = append(t.Bytes, e.Bytes)
+		}
+		if steady && t.Growth() > 0 {
+			trends = append(trends, t)
+		}
+	}
+
+	sort.Slice(trends, func(i, j int) bool {
+		if gi, gj := trends[i].Growth(), trends[j].Growth(); gi != gj {
+			return gi > gj
+		}
+		return trends[i].Class < trends[j].Class
+	})
+	return trends
+}
+
+// Collect takes count histograms, interval apart, with take
+// The first one is taken immediately
+func Collect(ctx context.Context, count int, interval time.Duration, take func(ctx context.Context) (*Histogram, error)) ([]*Histogram, error) {
+	histograms := make([]*Histogram, 0, count)
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return histograms, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		h, err := take(ctx)
+		if err != nil {
+			return histograms, err
+		}
+		histograms = append(histograms, h)
+	}
+
+	return histograms, nil
+}
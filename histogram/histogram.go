@@ -0,0 +1,167 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package histogram parses the class histograms printed by the inspectheap
+// command (GC.class_histogram) and compares them to find growing classes
+package histogram
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xxs-2/jattach-go/internal/javaprops"
+)
+
+// ErrNoHistogram is returned when the input holds no class histogram, for
+// example because the JVM replied with an error message
+var ErrNoHistogram = errors.New("no class histogram in input")
+
+// Histogram is a parsed class histogram
+type Histogram struct {
+	// Entries in the order they were printed, largest first
+	Entries []Entry `json:"entries"`
+
+	// TotalInstances and TotalBytes are from the Total line, or summed
+	// from the entries if there is none
+	TotalInstances int64 `json:"total_instances"`
+	TotalBytes     int64 `json:"total_bytes"`
+}
+
+// Entry is one row of a class histogram
+type Entry struct {
+	Rank      int    `json:"rank"`
+	Instances int64  `json:"instances"`
+	Bytes     int64  `json:"bytes"`
+	Class     string `json:"class"`
+
+	// Module is the module and version printed by JDK 9+, e.g.
+	// "java.base@17.0.2"
+	Module string `json:"module,omitempty"`
+}
+
+// key identifies a class across histograms. Classes with the same name
+// loaded by different class loaders are merged
+type key struct {
+	class  string
+	module string
+}
+
+func (e *Entry) key() key {
+	return key{e.Class, e.Module}
+}
+
+// Parse reads a class histogram. It understands the HotSpot format with and
+// without modules and the OpenJ9 format, either as text or as the raw
+// diagnostics reply
+// num     #instances         #bytes  class name (module)
+// -------------------------------------------------------
+//
+//	1:         12345         678900  [B (java.base@17.0.2)
+func Parse(r io.Reader) (*Histogram, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseString(string(data))
+}
+
+// ParseString parses a class histogram held in a string
+func ParseString(s string) (*Histogram, error) {
+	h := &Histogram{Entries: []Entry{}}
+	haveTotal := false
+
+	scanner := bufio.NewScanner(strings.NewReader(javaprops.DiagnosticsResult(s)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "Total" && len(fields) >= 3 {
+			h.TotalInstances, _ = strconv.ParseInt(fields[1], 10, 64)
+			h.TotalBytes, _ = strconv.ParseInt(fields[2], 10, 64)
+			haveTotal = true
+			continue
+		}
+
+		if e, ok := parseEntry(fields); ok {
+			h.Entries = append(h.Entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(h.Entries) == 0 && !haveTotal {
+		return nil, ErrNoHistogram
+	}
+
+	if !haveTotal {
+		for _, e := range h.Entries {
+			h.TotalInstances += e.Instances
+			h.TotalBytes += e.Bytes
+		}
+	}
+	return h, nil
+}
+
+// parseEntry parses the fields of a row: rank, instances, bytes, class
+// name and the optional module in parentheses
+func parseEntry(fields []string) (Entry, bool) {
+	if len(fields) < 4 {
+		return Entry{}, false
+	}
+
+	rank, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":"))
+	if err != nil {
+		return Entry{}, false
+	}
+	instances, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Entry{}, false
+	}
+	bytes, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	e := Entry{Rank: rank, Instances: instances, Bytes: bytes, Class: fields[3]}
+	if rest := strings.Join(fields[4:], " "); strings.HasPrefix(rest, "(") {
+		e.Module = strings.TrimSuffix(rest[1:], ")")
+	}
+	return e, true
+}
+
+// Find returns the entry of the given class, or nil
+// Classes loaded by several class loaders have several entries; the
+// first, largest one is returned
+func (h *Histogram) Find(class string) *Entry {
+	for i := range h.Entries {
+		if h.Entries[i].Class == class {
+			return &h.Entries[i]
+		}
+	}
+	return nil
+}
+
+// merged sums the entries of each class
+func (h *Histogram) merged() map[key]Entry {
+	m := make(map[key]Entry, len(h.Entries))
+	for _, e := range h.Entries {
+		k := e.key()
+		if prev, ok := m[k]; ok {
+			prev.Instances += e.Instances
+			prev.Bytes += e.Bytes
+			m[k] = prev
+			continue
+		}
+		m[k] = e
+	}
+	return m
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package histogram
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file   string
+		module string
+	}{
+		{"hotspot8.txt", ""},
+		{"hotspot21.txt", "java.base@21.0.3"},
+		{"openj9.txt", ""},
+		{"openj9-diagnostics.txt", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			h, err := Parse(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(h.Entries) != 3 || h.TotalInstances != 6762 || h.TotalBytes != 408392 {
+				t.Errorf("histogram = %+v", h)
+			}
+			e := h.Find("java.lang.Class")
+			if e == nil || e.Instances != 760 || e.Bytes != 86456 || e.Module != tt.module {
+				t.Errorf("java.lang.Class = %+v", e)
+			}
+		})
+	}
+}
+
+func TestParseNoHistogram(t *testing.T) {
+	_, err := ParseString("java.lang.IllegalArgumentException: Unknown diagnostic command\n")
+	if !errors.Is(err, ErrNoHistogram) {
+		t.Errorf("got %v, want ErrNoHistogram", err)
+	}
+}
+
+// histogram builds a histogram of classes and instance counts, at 16 bytes
+// per instance. The entries are not ranked
+func histogram(counts map[string]int64) *Histogram {
+	h := &Histogram{}
+	for class, n := range counts {
+		h.Entries = append(h.Entries, Entry{Class: class, Instances: n, Bytes: 16 * n})
+	}
+	return h
+}
+
+func TestDiff(t *testing.T) {
+	a := histogram(map[string]int64{"Leak": 10, "Gone": 5, "Steady": 7})
+	b := histogram(map[string]int64{"Leak": 30, "Steady": 7, "New": 2})
+
+	deltas := Diff(a, b)
+	want := []struct {
+		class     string
+		instances int64
+	}{{"Leak", 20}, {"New", 2}, {"Steady", 0}, {"Gone", -5}}
+	if len(deltas) != len(want) {
+		t.Fatalf("deltas = %+v", deltas)
+	}
+	for i, w := range want {
+		if deltas[i].Class != w.class || deltas[i].Instances != w.instances || deltas[i].Bytes != 16*w.instances {
+			t.Errorf("delta %d = %+v, want %s %+d", i, deltas[i], w.class, w.instances)
+		}
+	}
+}
+
+func TestDiffMergesClassLoaders(t *testing.T) {
+	a := &Histogram{Entries: []Entry{{Class: "Dup", Instances: 1, Bytes: 16}}}
+	b := &Histogram{Entries: []Entry{{Class: "Dup", Instances: 2, Bytes: 32}, {Class: "Dup", Instances: 3, Bytes: 48}}}
+
+	deltas := Diff(a, b)
+	if len(deltas) != 1 || deltas[0].Instances != 4 || deltas[0].After.Instances != 5 {
+		t.Errorf("deltas = %+v", deltas)
+	}
+}
+
+func TestGrowing(t *testing.T) {
+	hs := []*Histogram{
+		histogram(map[string]int64{"Leak": 10, "Spike": 5, "Slow": 1, "Flat": 3}),
+		histogram(map[string]int64{"Leak": 20, "Spike": 50, "Slow": 1, "Flat": 3}),
+		histogram(map[string]int64{"Leak": 40, "Spike": 6, "Slow": 2, "Flat": 3}),
+	}
+
+	trends := Growing(hs)
+	if len(trends) != 2 || trends[0].Class != "Leak" || trends[1].Class != "Slow" {
+		t.Fatalf("trends = %+v", trends)
+	}
+	if got := trends[0].Instances; len(got) != 3 || got[2] != 40 || trends[0].Growth() != 30 {
+		t.Errorf("Leak = %+v", trends[0])
+	}
+	if Growing(hs[:1]) != nil {
+		t.Error("trends from a single histogram")
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+func TestClassHistogram(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  func(t *testing.T, script jattachtest.Script) (*jattach.Client, *jattachtest.Server)
+		script jattachtest.Script
+		module string
+	}{
+		{"HotSpot 8", newHotSpot, jattachtest.HotSpot8(), ""},
+		{"HotSpot 17", newHotSpot, jattachtest.HotSpot17(), "java.base@17.0.11"},
+		{"OpenJ9", newOpenJ9, jattachtest.OpenJ9(), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, jvm := tt.start(t, tt.script)
+
+			h, err := client.ClassHistogram(context.Background(), jvm.PID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(h.Entries) != 3 || h.TotalInstances != 6762 || h.TotalBytes != 408392 {
+				t.Errorf("histogram = %+v", h)
+			}
+			if e := h.Find("java.lang.String"); e == nil || e.Instances != 2990 || e.Bytes != 71760 || e.Module != tt.module {
+				t.Errorf("java.lang.String = %+v", e)
+			}
+		})
+	}
+}
+
+func TestClassHistograms(t *testing.T) {
+	client, jvm := newHotSpot(t, jattachtest.HotSpot17())
+
+	hs, err := client.ClassHistograms(context.Background(), jvm.PID(), 3, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 3 {
+		t.Fatalf("got %d histograms, want 3", len(hs))
+	}
+	if got := commandKeys(jvm); got != "inspectheap, inspectheap, inspectheap" {
+		t.Errorf("commands = %s", got)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package hsperf reads the performance counters a HotSpot JVM publishes in
+// its hsperfdata_<user>/<pid> file. This requires neither the attach
+// mechanism nor a signal, so it is safe to poll at any rate
+package hsperf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xxs-2/jattach-go/internal/process"
+)
+
+const (
+	perfMagic = 0xcafec0c0
+
+	// Size of the PerfDataPrologue structure
+	prologueSize = 32
+
+	// Size of the fixed part of a PerfDataEntry structure
+	entryHeaderSize = 20
+)
+
+var (
+	// ErrBadMagic indicates the data is not a PerfData memory image
+	ErrBadMagic = errors.New("hsperf: bad magic number")
+
+	// ErrUnsupportedVersion indicates a PerfData version other than 2.x
+	ErrUnsupportedVersion = errors.New("hsperf: unsupported version")
+
+	// ErrNotAccessible indicates the JVM has not finished initializing
+	// the PerfData memory yet
+	ErrNotAccessible = errors.New("hsperf: not accessible")
+)
+
+// PerfData is a parsed snapshot of the PerfData memory of a JVM
+type PerfData struct {
+	// MajorVersion and MinorVersion of the PerfData format
+	MajorVersion int
+	MinorVersion int
+
+	// ModTimeStamp is the time of the last counter creation, in ticks
+	ModTimeStamp int64
+
+	// Counters holds all counters in file order
+	Counters []*Counter
+
+	byName map[string]*Counter
+}
+
+// Open reads the PerfData of the JVM with the given host PID
+// The file is located in the temporary directory the process sees, which
+// for containerized JVMs is /proc/<pid>/root/tmp
+func Open(pid int) (*PerfData, error) {
+	return OpenAt("", pid)
+}
+
+// OpenAt is like Open, with the process looked up in the procfs mounted at
+// procRoot, e.g. /host/proc in a sidecar. An empty procRoot is
+// JATTACH_PROC_ROOT if set, /proc otherwise
+func OpenAt(procRoot string, pid int) (*PerfData, error) {
+	path, err := FindAt(procRoot, pid)
+	if err != nil {
+		return nil, err
+	}
+	return ReadFile(path)
+}
+
+// Find returns the path of the hsperfdata file of the JVM with the given
+// host PID
+func Find(pid int) (string, error) {
+	return FindAt("", pid)
+}
+
+// FindAt is like Find, with the process looked up in the procfs mounted
+// at procRoot as for OpenAt
+func FindAt(procRoot string, pid int) (string, error) {
+	info, err := process.GetProcessInfo(procRoot, pid)
+	if err != nil {
+		return "", err
+	}
+
+	tmpPath, err := process.GetTmpPath(procRoot, pid)
+	if err != nil {
+		return "", err
+	}
+
+	return process.FindPerfData(tmpPath, info.NsPID)
+}
+
+// ReadFile reads and parses a hsperfdata file
+func ReadFile(path string) (*PerfData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses a PerfData v2 memory image
+func Parse(data []byte) (*PerfData, error) {
+	if len(data) < prologueSize {
+		return nil, fmt.Errorf("hsperf: short prologue (%d bytes)", len(data))
+	}
+
+	// The magic number is always stored big-endian
+	if binary.BigEndian.Uint32(data[0:4]) != perfMagic {
+		return nil, ErrBadMagic
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if data[4] == 1 {
+		order = binary.LittleEndian
+	}
+
+	pd := &PerfData{
+		MajorVersion: int(data[5]),
+		MinorVersion: int(data[6]),
+	}
+	if pd.MajorVersion != 2 {
+		return nil, fmt.Errorf("%w: %d.%d", ErrUnsupportedVersion, pd.MajorVersion, pd.MinorVersion)
+	}
+	if data[7] == 0 {
+		return nil, ErrNotAccessible
+	}
+
+	used := int(int32(order.Uint32(data[8:12])))
+	pd.ModTimeStamp = int64(order.Uint64(data[16:24]))
+	entryOffset := int(int32(order.Uint32(data[24:28])))
+	numEntries := int(int32(order.Uint32(data[28:32])))
+
+	if used > 0 && used < len(data) {
+		data = data[:used]
+	}
+
+	// The file may be written by anyone who can run a JVM, so the entry
+	// count only bounds the loop and never sizes an allocation
+	if numEntries < 0 || numEntries > len(data)/entryHeaderSize {
+		return nil, fmt.Errorf("hsperf: bad entry count %d", numEntries)
+	}
+	pd.Counters = make([]*Counter, 0, numEntries)
+	pd.byName = make(map[string]*Counter, numEntries)
+
+	offset := entryOffset
+	for i := 0; i < numEntries; i++ {
+		c, length, err := parseEntry(data, offset, order)
+		if err != nil {
+			return nil, fmt.Errorf("hsperf: entry %d: %w", i, err)
+		}
+		if c != nil {
+			pd.Counters = append(pd.Counters, c)
+			pd.byName[c.Name] = c
+		}
+		offset += length
+	}
+
+	return pd, nil
+}
+
+// parseEntry parses the PerfDataEntry at offset and returns it along with
+// its length. Entries of unsupported types are skipped with a nil counter
+// Every offset and length is a signed field that may have been forged, so
+// each is checked before slicing, in a form that cannot overflow
+func parseEntry(data []byte, offset int, order binary.ByteOrder) (*Counter, int, error) {
+	if offset < 0 || offset > len(data)-entryHeaderSize {
+		return nil, 0, fmt.Errorf("header out of bounds at %d", offset)
+	}
+	hdr := data[offset:]
+
+	entryLength := int(int32(order.Uint32(hdr[0:4])))
+	nameOffset := int(int32(order.Uint32(hdr[4:8])))
+	vectorLength := int(int32(order.Uint32(hdr[8:12])))
+	dataType := hdr[12]
+	units := Units(hdr[14])
+	variability := Variability(hdr[15])
+	dataOffset := int(int32(order.Uint32(hdr[16:20])))
+
+	if entryLength < entryHeaderSize || entryLength > len(data)-offset {
+		return nil, 0, fmt.Errorf("bad entry length %d", entryLength)
+	}
+	entry := data[offset : offset+entryLength]
+
+	if nameOffset < 0 || nameOffset >= entryLength || dataOffset < 0 || dataOffset > entryLength {
+		return nil, 0, fmt.Errorf("bad name or data offset")
+	}
+	if vectorLength < 0 {
+		return nil, 0, fmt.Errorf("bad vector length %d", vectorLength)
+	}
+	name := cString(entry[nameOffset:])
+
+	c := &Counter{
+		Name:        name,
+		Units:       units,
+		Variability: variability,
+	}
+
+	switch {
+	case dataType == 'J' && vectorLength == 0:
+		if dataOffset > entryLength-8 {
+			return nil, 0, fmt.Errorf("%s: value out of bounds", name)
+		}
+		c.Type = TypeLong
+		c.Long = int64(order.Uint64(entry[dataOffset:]))
+	case dataType == 'B' && vectorLength > 0:
+		if vectorLength > entryLength-dataOffset {
+			return nil, 0, fmt.Errorf("%s: value out of bounds", name)
+		}
+		c.Type = TypeString
+		c.String = cString(entry[dataOffset : dataOffset+vectorLength])
+	default:
+		// Other basic types are never exported by HotSpot
+		return nil, entryLength, nil
+	}
+
+	return c, entryLength, nil
+}
+
+// cString returns the bytes up to the first NUL as a string
+func cString(b []byte) string {
+	if idx := bytes.IndexByte(b, 0); idx != -1 {
+		b = b[:idx]
+	}
+	return string(b)
+}
+
+// Counter returns the counter with the given name, or nil
+func (pd *PerfData) Counter(name string) *Counter {
+	return pd.byName[name]
+}
+
+// LongValue returns the value of a TypeLong counter
+func (pd *PerfData) LongValue(name string) (int64, bool) {
+	c := pd.byName[name]
+	if c == nil || c.Type != TypeLong {
+		return 0, false
+	}
+	return c.Long, true
+}
+
+// StringValue returns the value of a TypeString counter
+func (pd *PerfData) StringValue(name string) (string, bool) {
+	c := pd.byName[name]
+	if c == nil || c.Type != TypeString {
+		return "", false
+	}
+	return c.String, true
+}
+
+// Prefix returns the counters whose name starts with prefix, sorted by name
+func (pd *PerfData) Prefix(prefix string) []*Counter {
+	var counters []*Counter
+	for _, c := range pd.Counters {
+		if strings.HasPrefix(c.Name, prefix) {
+			counters = append(counters, c)
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i].Name < counters[j].Name })
+	return counters
+}
+
+// Frequency returns the tick frequency of the JVM's high-resolution timer
+// (sun.os.hrt.frequency), used to convert UnitsTicks counters
+func (pd *PerfData) Frequency() int64 {
+	if freq, ok := pd.LongValue("sun.os.hrt.frequency"); ok && freq > 0 {
+		return freq
+	}
+	return int64(time.Second)
+}
+
+// Duration converts a tick count of this JVM to a time.Duration
+func (pd *PerfData) Duration(ticks int64) time.Duration {
+	freq := pd.Frequency()
+	return time.Duration(float64(ticks) / float64(freq) * float64(time.Second))
+}
+
+// JavaCommand returns the main class and arguments of the JVM
+// (sun.rt.javaCommand)
+func (pd *PerfData) JavaCommand() string {
+	cmd, _ := pd.StringValue("sun.rt.javaCommand")
+	return cmd
+}
+
+// JavaVersion returns the java.version system property of the JVM
+func (pd *PerfData) JavaVersion() string {
+	version, _ := pd.StringValue("java.property.java.version")
+	return version
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package hsperf
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testEntry is a PerfDataEntry, with its header fields overridable to
+// forge hostile entries
+type testEntry struct {
+	name   string
+	typ    byte
+	long   int64
+	str    string
+	length *int32 // entry_length, computed if nil
+	nameAt *int32 // name_offset, computed if nil
+	dataAt *int32 // data_offset, computed if nil
+	vector *int32 // vector_length, computed if nil
+}
+
+func ptr(v int32) *int32 { return &v }
+
+// encode returns the entry in little-endian byte order
+func (e testEntry) encode() []byte {
+	name := append([]byte(e.name), 0)
+	for (entryHeaderSize+len(name))%8 != 0 {
+		name = append(name, 0)
+	}
+	var value []byte
+	vector := int32(0)
+	if e.typ == 'J' {
+		value = binary.LittleEndian.AppendUint64(nil, uint64(e.long))
+	} else {
+		value = append([]byte(e.str), 0)
+		vector = int32(len(value))
+	}
+
+	length := int32(entryHeaderSize + len(name) + len(value))
+	nameAt := int32(entryHeaderSize)
+	dataAt := int32(entryHeaderSize + len(name))
+	override := func(field *int32, v *int32) {
+		if v != nil {
+			*field = *v
+		}
+	}
+	override(&length, e.length)
+	override(&nameAt, e.nameAt)
+	override(&dataAt, e.dataAt)
+	override(&vector, e.vector)
+
+	b := binary.LittleEndian.AppendUint32(nil, uint32(length))
+	b = binary.LittleEndian.AppendUint32(b, uint32(nameAt))
+	b = binary.LittleEndian.AppendUint32(b, uint32(vector))
+	b = append(b, e.typ, 0, byte(UnitsNone), byte(VariabilityConstant))
+	b = binary.LittleEndian.AppendUint32(b, uint32(dataAt))
+	b = append(b, name...)
+	return append(b, value...)
+}
+
+// image returns a PerfData memory image holding entries
+func image(entries ...testEntry) []byte {
+	var body []byte
+	for _, e := range entries {
+		body = append(body, e.encode()...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, perfMagic)
+	b = append(b, 1, 2, 0, 1)
+	b = binary.LittleEndian.AppendUint32(b, uint32(prologueSize+len(body)))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint64(b, 42)
+	b = binary.LittleEndian.AppendUint32(b, prologueSize)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(entries)))
+	return append(b, body...)
+}
+
+func TestParse(t *testing.T) {
+	pd, err := Parse(image(
+		testEntry{name: "sun.os.hrt.frequency", typ: 'J', long: 1000000000},
+		testEntry{name: "java.property.java.version", typ: 'B', str: "21.0.3"},
+		testEntry{name: "sun.rt.javaCommand", typ: 'B', str: "Main --port 8080"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pd.MajorVersion != 2 || pd.MinorVersion != 0 || pd.ModTimeStamp != 42 {
+		t.Errorf("prologue = %d.%d at %d", pd.MajorVersion, pd.MinorVersion, pd.ModTimeStamp)
+	}
+	if freq := pd.Frequency(); freq != 1000000000 {
+		t.Errorf("Frequency() = %d", freq)
+	}
+	if v := pd.JavaVersion(); v != "21.0.3" {
+		t.Errorf("JavaVersion() = %q", v)
+	}
+	if cmd := pd.JavaCommand(); cmd != "Main --port 8080" {
+		t.Errorf("JavaCommand() = %q", cmd)
+	}
+	if _, ok := pd.LongValue("sun.rt.javaCommand"); ok {
+		t.Error("LongValue() of a string counter")
+	}
+	if _, ok := pd.StringValue("sun.os.hrt.frequency"); ok {
+		t.Error("StringValue() of a long counter")
+	}
+}
+
+func TestParseBadImage(t *testing.T) {
+	valid := image(testEntry{name: "sun.os.hrt.frequency", typ: 'J', long: 1000})
+
+	badMagic := append([]byte(nil), valid...)
+	badMagic[0] = 0
+	if _, err := Parse(badMagic); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("bad magic: got %v, want ErrBadMagic", err)
+	}
+
+	badVersion := append([]byte(nil), valid...)
+	badVersion[5] = 1
+	if _, err := Parse(badVersion); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("version 1: got %v, want ErrUnsupportedVersion", err)
+	}
+
+	notAccessible := append([]byte(nil), valid...)
+	notAccessible[7] = 0
+	if _, err := Parse(notAccessible); !errors.Is(err, ErrNotAccessible) {
+		t.Errorf("not accessible: got %v, want ErrNotAccessible", err)
+	}
+}
+
+func TestParseRejectsTruncatedAndHostileData(t *testing.T) {
+	valid := image(testEntry{name: "sun.os.hrt.frequency", typ: 'J', long: 1000})
+
+	countAt := func(n int32) []byte {
+		b := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint32(b[28:], uint32(n))
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short prologue", valid[:prologueSize-1]},
+		{"truncated header", valid[:prologueSize+entryHeaderSize-1]},
+		{"truncated entry", valid[:len(valid)-1]},
+		{"negative entry count", countAt(-1)},
+		{"huge entry count", countAt(1 << 30)},
+		{"extra entry", countAt(2)},
+		{"short entry length", image(testEntry{name: "a", typ: 'J', length: ptr(entryHeaderSize - 1)})},
+		{"negative entry length", image(testEntry{name: "a", typ: 'J', length: ptr(-8)})},
+		{"entry past end", image(testEntry{name: "a", typ: 'J', length: ptr(1 << 30)})},
+		{"negative name offset", image(testEntry{name: "a", typ: 'J', nameAt: ptr(-1)})},
+		{"name offset past entry", image(testEntry{name: "a", typ: 'J', nameAt: ptr(1 << 20)})},
+		{"negative data offset", image(testEntry{name: "a", typ: 'J', dataAt: ptr(-8)})},
+		{"long past entry", image(testEntry{name: "a", typ: 'J', dataAt: ptr(30)})},
+		{"negative string data offset", image(testEntry{name: "a", typ: 'B', str: "x", dataAt: ptr(-1)})},
+		{"negative vector length", image(testEntry{name: "a", typ: 'B', str: "x", vector: ptr(-1)})},
+		{"vector past entry", image(testEntry{name: "a", typ: 'B', str: "x", vector: ptr(1<<31 - 1)})},
+		{"vector overflow", image(testEntry{name: "a", typ: 'B', str: "x", dataAt: ptr(24), vector: ptr(1<<31 - 20)})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd, err := Parse(tt.data)
+			if err == nil {
+				t.Errorf("Parse() = %d counters, want an error", len(pd.Counters))
+			}
+		})
+	}
+}
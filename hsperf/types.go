@@ -0,0 +1,125 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package hsperf
+
+import "strconv"
+
+// Units describes what a counter measures
+type Units int
+
+const (
+	// UnitsInvalid marks a malformed entry
+	UnitsInvalid Units = iota
+	// UnitsNone is a plain number
+	UnitsNone
+	// UnitsBytes is a size in bytes
+	UnitsBytes
+	// UnitsTicks is a duration in high-resolution timer ticks
+	UnitsTicks
+	// UnitsEvents is a count of events
+	UnitsEvents
+	// UnitsString is a text value
+	UnitsString
+	// UnitsHertz is a frequency
+	UnitsHertz
+)
+
+func (u Units) String() string {
+	switch u {
+	case UnitsNone:
+		return "None"
+	case UnitsBytes:
+		return "Bytes"
+	case UnitsTicks:
+		return "Ticks"
+	case UnitsEvents:
+		return "Events"
+	case UnitsString:
+		return "String"
+	case UnitsHertz:
+		return "Hertz"
+	default:
+		return "Invalid"
+	}
+}
+
+// Variability describes how a counter changes over the life of the JVM
+type Variability int
+
+const (
+	// VariabilityInvalid marks a malformed entry
+	VariabilityInvalid Variability = iota
+	// VariabilityConstant counters never change after creation
+	VariabilityConstant
+	// VariabilityMonotonic counters only grow
+	VariabilityMonotonic
+	// VariabilityVariable counters may change in either direction
+	VariabilityVariable
+)
+
+func (v Variability) String() string {
+	switch v {
+	case VariabilityConstant:
+		return "Constant"
+	case VariabilityMonotonic:
+		return "Monotonic"
+	case VariabilityVariable:
+		return "Variable"
+	default:
+		return "Invalid"
+	}
+}
+
+// Type is the data type of a counter value
+type Type int
+
+const (
+	// TypeLong is a 64-bit integer counter
+	TypeLong Type = iota
+	// TypeString is a byte vector holding a NUL-terminated string
+	TypeString
+)
+
+func (t Type) String() string {
+	if t == TypeString {
+		return "String"
+	}
+	return "Long"
+}
+
+// Counter is one entry of the PerfData memory
+type Counter struct {
+	// Name is the counter name, e.g. "sun.gc.collector.0.invocations"
+	Name string
+
+	// Type selects which of Long and String holds the value
+	Type Type
+
+	Units       Units
+	Variability Variability
+
+	// Long is the value of a TypeLong counter
+	Long int64
+
+	// String is the value of a TypeString counter
+	String string
+}
+
+// Value returns the counter value as int64 or string
+func (c *Counter) Value() interface{} {
+	if c.Type == TypeString {
+		return c.String
+	}
+	return c.Long
+}
+
+// FormatValue returns the counter value as text
+func (c *Counter) FormatValue() string {
+	if c.Type == TypeString {
+		return c.String
+	}
+	return strconv.FormatInt(c.Long, 10)
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package javaprops decodes text in the java.util.Properties file format,
+// as printed by the properties commands and OpenJ9 diagnostics replies
+package javaprops
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Parse decodes the properties in s. Later keys override earlier ones, as
+// with Properties.load
+func Parse(s string) map[string]string {
+	props := make(map[string]string)
+	for _, line := range logicalLines(s) {
+		key, value := splitLine(line)
+		props[unescape(key)] = unescape(value)
+	}
+	return props
+}
+
+// DiagnosticsResult extracts the text from an OpenJ9 diagnostics reply,
+// which is a properties file with the escaped text in one property
+// Any other input is returned as is
+func DiagnosticsResult(s string) string {
+	const key = "openj9_diagnostics.string_result"
+	if !strings.Contains(s, key+"=") {
+		return s
+	}
+	if result, ok := Parse(s)[key]; ok {
+		return result
+	}
+	return s
+}
+
+// logicalLines joins continued lines and drops comments and blank lines
+func logicalLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+
+	var lines []string
+	var current strings.Builder
+	continued := false
+
+	for _, natural := range strings.Split(s, "\n") {
+		trimmed := strings.TrimLeft(natural, " \t\f")
+		if !continued && (trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!') {
+			continue
+		}
+
+		// An odd number of trailing backslashes continues the line
+		backslashes := len(trimmed) - len(strings.TrimRight(trimmed, "\\"))
+		continued = backslashes%2 == 1
+		if continued {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+
+		current.WriteString(trimmed)
+		if !continued {
+			lines = append(lines, current.String())
+			current.Reset()
+		}
+	}
+
+	if current.Len() > 0 {
+		lines = append(lines, current.String())
+	}
+	return lines
+}
+
+// splitLine separates the key from the value at the first unescaped '=',
+// ':' or whitespace
+func splitLine(line string) (string, string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			end = i
+			break
+		}
+	}
+
+	key, rest := line[:end], line[end:]
+	rest = strings.TrimLeft(rest, " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return key, rest
+}
+
+// unescape resolves backslash escapes, including \uXXXX
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var sb strings.Builder
+	var units []uint16
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			flushUTF16(&sb, &units)
+			sb.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch c = s[i]; c {
+		case 't':
+			c = '\t'
+		case 'n':
+			c = '\n'
+		case 'r':
+			c = '\r'
+		case 'f':
+			c = '\f'
+		case 'u':
+			if i+4 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+5], 16, 16); err == nil {
+					// Collected so that surrogate pairs combine
+					units = append(units, uint16(v))
+					i += 4
+					continue
+				}
+			}
+		}
+		flushUTF16(&sb, &units)
+		sb.WriteByte(c)
+	}
+
+	flushUTF16(&sb, &units)
+	return sb.String()
+}
+
+// flushUTF16 writes pending \u escapes as UTF-8
+func flushUTF16(sb *strings.Builder, units *[]uint16) {
+	if len(*units) == 0 {
+		return
+	}
+	for _, r := range utf16.Decode(*units) {
+		sb.WriteRune(r)
+	}
+	*units = (*units)[:0]
+}
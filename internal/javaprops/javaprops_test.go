@@ -0,0 +1,59 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package javaprops
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	const input = "#Wed May 01 10:00:00 UTC 2024\r\n" +
+		"! comment\n" +
+		"plain=value\n" +
+		"spaced   :  value with spaces\n" +
+		"key\\=with\\:separators=ok\n" +
+		"escapes=tab\\there\\nnewline\\\\backslash\n" +
+		"unicode=caf\\u00e9 \\ud83d\\ude00\n" +
+		"continued=first \\\n    second\n" +
+		"   indented=yes\n" +
+		"empty=\n" +
+		"keyonly\n" +
+		"plain=overridden\n"
+
+	want := map[string]string{
+		"plain":               "overridden",
+		"spaced":              "value with spaces",
+		"key=with:separators": "ok",
+		"escapes":             "tab\there\nnewline\\backslash",
+		"unicode":             "café 😀",
+		"continued":           "first second",
+		"indented":            "yes",
+		"empty":               "",
+		"keyonly":             "",
+	}
+
+	got := Parse(input)
+	if len(got) != len(want) {
+		t.Errorf("got %d properties, want %d: %q", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestDiagnosticsResult(t *testing.T) {
+	const reply = "#Wed May 01 10:00:00 UTC 2024\n" +
+		"openj9_diagnostics.string_result=Dump written to /tmp/javacore.txt\\n\\tdone\n" +
+		"openj9_diagnostics.result_type=string\n"
+	if got := DiagnosticsResult(reply); got != "Dump written to /tmp/javacore.txt\n\tdone" {
+		t.Errorf("DiagnosticsResult() = %q", got)
+	}
+
+	const text = "35.112 s\n"
+	if got := DiagnosticsResult(text); got != text {
+		t.Errorf("DiagnosticsResult(%q) = %q", text, got)
+	}
+}
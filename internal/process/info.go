@@ -5,19 +5,54 @@
 
 package process
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
 
 // Info contains process information needed for attachment
 type Info struct {
 	UID   uint32 // Effective user ID
 	GID   uint32 // Effective group ID
 	NsPID int    // PID inside container namespace (or regular PID)
+
+	// StartTime tells the process apart from a later one with the same
+	// PID (Linux only, zero elsewhere)
+	StartTime uint64
+}
+
+// ProcRoot is the procfs mount used by lookups that are given no root of
+// their own: JATTACH_PROC_ROOT if set, /proc otherwise
+// A privileged sidecar may see the host's procfs at e.g. /host/proc
+var ProcRoot = defaultProcRoot()
+
+func defaultProcRoot() string {
+	if root := os.Getenv("JATTACH_PROC_ROOT"); root != "" {
+		return root
+	}
+	return "/proc"
+}
+
+// Root returns procRoot, or ProcRoot if procRoot is empty
+func Root(procRoot string) string {
+	if procRoot == "" {
+		return ProcRoot
+	}
+	return procRoot
+}
+
+// procPath returns the path of elem under Root(procRoot)
+func procPath(procRoot string, elem ...string) string {
+	return filepath.Join(append([]string{Root(procRoot)}, elem...)...)
 }
 
 // GetTmpPath returns the temporary directory path for the given PID
-// This may be container-specific on Linux
-func GetTmpPath(pid int) (string, error) {
-	path, err := getTmpPathPlatform(pid)
+// This may be container-specific on Linux. procRoot is the procfs mount
+// to look the process up in, empty for ProcRoot
+func GetTmpPath(procRoot string, pid int) (string, error) {
+	path, err := getTmpPathPlatform(procRoot, pid)
 	if err != nil {
 		// Check environment variable override
 		if jattachPath := os.Getenv("JATTACH_PATH"); jattachPath != "" {
@@ -27,3 +62,22 @@ func GetTmpPath(pid int) (string, error) {
 	}
 	return path, nil
 }
+
+// FindPerfData returns the path of the hsperfdata file published by the
+// HotSpot JVM with the given namespace PID under tmpPath
+// The file lives in hsperfdata_<user>, where the user name is the one
+// known inside the container, so every such directory is checked
+func FindPerfData(tmpPath string, nspid int) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(tmpPath, "hsperfdata_*", strconv.Itoa(nspid)))
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no hsperfdata file for pid %d in %s", nspid, tmpPath)
+}
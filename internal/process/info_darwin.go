@@ -17,7 +17,7 @@ import (
 
 // GetProcessInfo retrieves process information for the given PID on macOS
 // Uses sysctl with KERN_PROC to get process credentials
-func GetProcessInfo(pid int) (*Info, error) {
+func GetProcessInfo(procRoot string, pid int) (*Info, error) {
 	mib := []int32{1, 14, 1, int32(pid)} // CTL_KERN, KERN_PROC, KERN_PROC_PID, pid
 
 	var kinfo unix.KinfoProc
@@ -46,7 +46,7 @@ func GetProcessInfo(pid int) (*Info, error) {
 
 // getTmpPathPlatform returns the user-specific temp directory on macOS
 // macOS uses a secure per-user temporary directory
-func getTmpPathPlatform(pid int) (string, error) {
+func getTmpPathPlatform(procRoot string, pid int) (string, error) {
 	// macOS uses TMPDIR environment variable for per-user temp
 	tmpDir := os.Getenv("TMPDIR")
 	if tmpDir == "" {
@@ -16,7 +16,7 @@ import (
 
 // GetProcessInfo retrieves process information for the given PID on FreeBSD
 // Uses sysctl with KERN_PROC to get process credentials
-func GetProcessInfo(pid int) (*Info, error) {
+func GetProcessInfo(procRoot string, pid int) (*Info, error) {
 	mib := []int32{1, 14, 1, int32(pid)} // CTL_KERN, KERN_PROC, KERN_PROC_PID, pid
 
 	var kinfo unix.KinfoProc
@@ -45,6 +45,6 @@ func GetProcessInfo(pid int) (*Info, error) {
 }
 
 // getTmpPathPlatform returns the default /tmp on FreeBSD
-func getTmpPathPlatform(pid int) (string, error) {
+func getTmpPathPlatform(procRoot string, pid int) (string, error) {
 	return "/tmp", nil
 }
@@ -18,9 +18,10 @@ import (
 )
 
 // GetProcessInfo retrieves process information for the given PID on Linux
-// Parses /proc/[pid]/status for UID, GID, and NStgid fields
-func GetProcessInfo(pid int) (*Info, error) {
-	statusPath := filepath.Join("/proc", strconv.Itoa(pid), "status")
+// Parses /proc/[pid]/status for UID, GID, and NStgid fields, under
+// procRoot or ProcRoot if empty
+func GetProcessInfo(procRoot string, pid int) (*Info, error) {
+	statusPath := procPath(procRoot, strconv.Itoa(pid), "status")
 	f, err := os.Open(statusPath)
 	if err != nil {
 		return nil, fmt.Errorf("process %d not found: %w", pid, err)
@@ -67,7 +68,12 @@ func GetProcessInfo(pid int) (*Info, error) {
 
 	// Fallback for kernels < 4.1 that don't have NStgid field
 	if !nspidFound {
-		info.NsPID = altLookupNsPID(pid)
+		info.NsPID = altLookupNsPID(procRoot, pid)
+	}
+
+	info.StartTime, err = StartTime(procRoot, pid)
+	if err != nil {
+		return nil, err
 	}
 
 	return info, nil
@@ -75,12 +81,12 @@ func GetProcessInfo(pid int) (*Info, error) {
 
 // altLookupNsPID finds the container PID for old kernels < 4.1
 // that don't export NStgid in /proc/pid/status
-func altLookupNsPID(pid int) int {
-	pidNsPath := filepath.Join("/proc", strconv.Itoa(pid), "ns", "pid")
+func altLookupNsPID(procRoot string, pid int) int {
+	pidNsPath := procPath(procRoot, strconv.Itoa(pid), "ns", "pid")
 
 	// Check if we're in the same PID namespace
 	var oldNsStat, newNsStat syscall.Stat_t
-	if syscall.Stat("/proc/self/ns/pid", &oldNsStat) == nil &&
+	if syscall.Stat(procPath(procRoot, "self", "ns", "pid"), &oldNsStat) == nil &&
 		syscall.Stat(pidNsPath, &newNsStat) == nil {
 		if oldNsStat.Ino == newNsStat.Ino {
 			return pid // Same namespace
@@ -89,8 +95,8 @@ func altLookupNsPID(pid int) int {
 
 	// Browse all PIDs in the namespace of the target process
 	// trying to find which one corresponds to the host PID
-	procPath := filepath.Join("/proc", strconv.Itoa(pid), "root", "proc")
-	dir, err := os.Open(procPath)
+	nsProcPath := procPath(procRoot, strconv.Itoa(pid), "root", "proc")
+	dir, err := os.Open(nsProcPath)
 	if err != nil {
 		return pid
 	}
@@ -107,7 +113,7 @@ func altLookupNsPID(pid int) int {
 		}
 
 		// Check if /proc/<container-pid>/sched points back to <host-pid>
-		schedPath := filepath.Join("/proc", strconv.Itoa(pid), "root", "proc", entry, "sched")
+		schedPath := filepath.Join(nsProcPath, entry, "sched")
 		if schedGetHostPID(schedPath) == pid {
 			nspid, _ := strconv.Atoi(entry)
 			return nspid
@@ -155,8 +161,8 @@ func schedGetHostPID(path string) int {
 
 // getTmpPathPlatform returns the /tmp path for the given PID on Linux
 // For containerized processes, this is /proc/[pid]/root/tmp
-func getTmpPathPlatform(pid int) (string, error) {
-	path := filepath.Join("/proc", strconv.Itoa(pid), "root", "tmp")
+func getTmpPathPlatform(procRoot string, pid int) (string, error) {
+	path := procPath(procRoot, strconv.Itoa(pid), "root", "tmp")
 	var st syscall.Stat_t
 	if err := syscall.Stat(path, &st); err != nil {
 		return "/tmp", err
@@ -0,0 +1,29 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package process
+
+// ReducesSignals reports whether the JVM command line asks it not to
+// handle SIGQUIT, with -Xrs or -XX:+ReduceSignalUsage. Such a JVM never
+// starts its attach listener on SIGQUIT and dies from it instead
+// Options given through JAVA_TOOL_OPTIONS do not show up here
+func ReducesSignals(procRoot string, pid int) bool {
+	args, err := Cmdline(procRoot, pid)
+	if err != nil {
+		return false
+	}
+
+	// The last occurrence wins, as in the JVM
+	reduced := false
+	for _, arg := range args {
+		switch arg {
+		case "-Xrs", "-XX:+ReduceSignalUsage":
+			reduced = true
+		case "-XX:-ReduceSignalUsage":
+			reduced = false
+		}
+	}
+	return reduced
+}
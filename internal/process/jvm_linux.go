@@ -0,0 +1,73 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+//go:build linux
+
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// IsJVM looks for evidence that the process is a JVM: libjvm.so (HotSpot)
+// or libj9vm*.so (OpenJ9) in /proc/[pid]/maps, or an executable named java
+func IsJVM(procRoot string, pid int) bool {
+	if exe, err := os.Readlink(procPath(procRoot, strconv.Itoa(pid), "exe")); err == nil {
+		if filepath.Base(strings.TrimSuffix(exe, " (deleted)")) == "java" {
+			return true
+		}
+	}
+
+	f, err := os.Open(procPath(procRoot, strconv.Itoa(pid), "maps"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The mapped file is the last of six fields
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		lib := filepath.Base(fields[len(fields)-1])
+		if lib == "libjvm.so" || (strings.HasPrefix(lib, "libj9vm") && strings.HasSuffix(lib, ".so")) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlesSignal reports whether the process has installed a handler for
+// sig, according to the SigCgt mask of /proc/[pid]/status
+func HandlesSignal(procRoot string, pid int, sig syscall.Signal) (bool, error) {
+	f, err := os.Open(procPath(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return false, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "SigCgt:" {
+			continue
+		}
+		mask, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid SigCgt of process %d: %w", pid, err)
+		}
+		return mask&(1<<(uint(sig)-1)) != 0, nil
+	}
+
+	return false, fmt.Errorf("no SigCgt in /proc/%d/status", pid)
+}
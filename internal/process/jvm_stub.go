@@ -0,0 +1,24 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+//go:build !linux
+
+package process
+
+import (
+	"errors"
+	"syscall"
+)
+
+// IsJVM always reports true on non-Linux platforms, which have no
+// /proc/[pid]/maps to look into
+func IsJVM(procRoot string, pid int) bool {
+	return true
+}
+
+// HandlesSignal is not supported on non-Linux platforms
+func HandlesSignal(procRoot string, pid int, sig syscall.Signal) (bool, error) {
+	return false, errors.New("signal dispositions are not available on this platform")
+}
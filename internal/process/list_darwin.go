@@ -0,0 +1,70 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+//go:build darwin
+
+package process
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListPIDs returns the PIDs of all processes on macOS
+// Uses sysctl with KERN_PROC_ALL
+func ListPIDs(procRoot string) ([]int, error) {
+	procs, err := unix.SysctlKinfoProcSlice("kern.proc.all")
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(procs))
+	for _, proc := range procs {
+		if proc.Proc.P_pid > 0 {
+			pids = append(pids, int(proc.Proc.P_pid))
+		}
+	}
+
+	return pids, nil
+}
+
+// Cmdline returns the command line arguments of the given process on macOS
+// Parses KERN_PROCARGS2: argc, executable path, padding, then argv
+func Cmdline(procRoot string, pid int) ([]string, error) {
+	data, err := unix.SysctlRaw("kern.procargs2", pid)
+	if err != nil {
+		return nil, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("short KERN_PROCARGS2 for process %d", pid)
+	}
+
+	argc := int(binary.LittleEndian.Uint32(data[:4]))
+	data = data[4:]
+
+	// Skip the executable path and the NUL padding after it
+	if idx := bytes.IndexByte(data, 0); idx != -1 {
+		data = data[idx:]
+	}
+	data = bytes.TrimLeft(data, "\x00")
+
+	args := make([]string, 0, argc)
+	for len(args) < argc && len(data) > 0 {
+		idx := bytes.IndexByte(data, 0)
+		if idx == -1 {
+			idx = len(data)
+		}
+		args = append(args, string(data[:idx]))
+		if idx == len(data) {
+			break
+		}
+		data = data[idx+1:]
+	}
+
+	return args, nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+//go:build freebsd
+
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListPIDs returns the PIDs of processes that published attach files in
+// /tmp on FreeBSD. There are no containers to look into, so the hsperfdata
+// and OpenJ9 attach directories name every attachable JVM
+func ListPIDs(procRoot string) ([]int, error) {
+	seen := make(map[int]bool)
+
+	dirs, _ := filepath.Glob("/tmp/hsperfdata_*")
+	dirs = append(dirs, "/tmp/.com_ibm_tools_attach")
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if pid, err := strconv.Atoi(entry.Name()); err == nil && pid > 0 {
+				seen[pid] = true
+			}
+		}
+	}
+
+	pids := make([]int, 0, len(seen))
+	for pid := range seen {
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// Cmdline returns the command line arguments of the given process on FreeBSD
+// Uses sysctl with KERN_PROC_ARGS
+func Cmdline(procRoot string, pid int) ([]string, error) {
+	data, err := unix.SysctlRaw("kern.proc.args", pid)
+	if err != nil {
+		return nil, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var args []string
+	for _, arg := range bytes.Split(data, []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
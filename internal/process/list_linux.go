@@ -0,0 +1,66 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+//go:build linux
+
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ListPIDs returns the PIDs of all processes visible in procRoot, or in
+// ProcRoot if empty
+func ListPIDs(procRoot string) ([]int, error) {
+	dir, err := os.Open(procPath(procRoot))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	entries, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry) == 0 || entry[0] < '1' || entry[0] > '9' {
+			continue
+		}
+		if pid, err := strconv.Atoi(entry); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+// Cmdline returns the command line arguments of the given process
+// Parses the NUL-separated /proc/[pid]/cmdline
+func Cmdline(procRoot string, pid int) ([]string, error) {
+	data, err := os.ReadFile(procPath(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+	return splitArgs(data), nil
+}
+
+// splitArgs splits a NUL-separated argument list
+func splitArgs(data []byte) []string {
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil
+	}
+
+	var args []string
+	for _, arg := range bytes.Split(data, []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args
+}
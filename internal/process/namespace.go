@@ -0,0 +1,22 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package process
+
+import "fmt"
+
+// NamespaceError reports a namespace that could not be entered
+type NamespaceError struct {
+	Type string // Namespace type, e.g. "net"
+	Err  error  // Underlying error
+}
+
+func (e *NamespaceError) Error() string {
+	return fmt.Sprintf("failed to enter %s namespace: %v", e.Type, e.Err)
+}
+
+func (e *NamespaceError) Unwrap() error {
+	return e.Err
+}
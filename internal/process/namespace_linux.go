@@ -9,45 +9,127 @@ package process
 
 import (
 	"fmt"
-	"path/filepath"
+	"runtime"
 	"strconv"
 	"syscall"
 
 	"golang.org/x/sys/unix"
 )
 
-// EnterNamespace switches to the namespace of the target process
-// Returns: 1 if switched, 0 if already in same namespace, error if failed
-func EnterNamespace(pid int, nsType string) (int, error) {
-	selfPath := filepath.Join("/proc/self/ns", nsType)
-	targetPath := filepath.Join("/proc", strconv.Itoa(pid), "ns", nsType)
+// SameNamespace reports whether the current process already shares the
+// given namespace with the target process, looked up under procRoot or
+// ProcRoot if empty
+func SameNamespace(procRoot string, pid int, nsType string) bool {
+	selfPath := procPath(procRoot, "self", "ns", nsType)
+	targetPath := procPath(procRoot, strconv.Itoa(pid), "ns", nsType)
 
 	var selfStat, targetStat syscall.Stat_t
-	if err := syscall.Stat(selfPath, &selfStat); err != nil {
-		return -1, fmt.Errorf("failed to stat self namespace: %w", err)
-	}
-	if err := syscall.Stat(targetPath, &targetStat); err != nil {
-		return -1, fmt.Errorf("failed to stat target namespace: %w", err)
+	if syscall.Stat(selfPath, &selfStat) != nil || syscall.Stat(targetPath, &targetStat) != nil {
+		return true
 	}
+	return selfStat.Ino == targetStat.Ino
+}
 
-	// Already in the same namespace
-	if selfStat.Ino == targetStat.Ino {
-		return 0, nil
-	}
+// WithNamespaces runs fn on a dedicated OS thread that has joined the given
+// namespaces of the target process, found under procRoot or ProcRoot if
+// empty. Namespaces already shared with the
+// target are skipped. If any namespace cannot be entered, fn is not run and
+// a *NamespaceError is returned.
+//
+// The original namespaces are restored after fn returns. A thread that
+// cannot be restored exits instead of going back to the Go scheduler. This
+// is always the case for "mnt", which requires the thread to stop sharing
+// its filesystem attributes with the rest of the process
+func WithNamespaces(procRoot string, pid int, nsTypes []string, fn func() error) error {
+	errc := make(chan error, 1)
 
-	// Open the target namespace
-	// 使用 unix.O_CLOEXEC 确保文件描述符不会在 exec 时泄露
-	fd, err := unix.Open(targetPath, unix.O_RDONLY|unix.O_CLOEXEC, 0)
-	if err != nil {
-		return -1, fmt.Errorf("failed to open namespace: %w", err)
-	}
-	defer unix.Close(fd)
+	go func() {
+		runtime.LockOSThread()
+		tainted := false
+		defer func() {
+			// A tainted thread is discarded when this goroutine exits locked
+			if !tainted {
+				runtime.UnlockOSThread()
+			}
+		}()
+
+		entered, err := enterNamespaces(procRoot, pid, nsTypes, &tainted)
+		if err == nil {
+			err = fn()
+		}
+
+		// Restore in reverse order of entry
+		for i := len(entered) - 1; i >= 0; i-- {
+			if unix.Setns(entered[i], 0) != nil {
+				tainted = true
+			}
+			unix.Close(entered[i])
+		}
+
+		errc <- err
+	}()
+
+	return <-errc
+}
+
+// enterNamespaces switches the current thread to the target namespaces
+// Returns descriptors of the namespaces left, kept open so the thread can
+// return to them. On failure the ones left so far are returned for restoring
+func enterNamespaces(procRoot string, pid int, nsTypes []string, tainted *bool) ([]int, error) {
+	var entered []int
+
+	for _, nsType := range nsTypes {
+		// thread-self, unlike task/<tid>, also resolves in a procfs of an
+		// ancestor PID namespace
+		selfPath := procPath(procRoot, "thread-self", "ns", nsType)
+		targetPath := procPath(procRoot, strconv.Itoa(pid), "ns", nsType)
+
+		var selfStat, targetStat syscall.Stat_t
+		if err := syscall.Stat(selfPath, &selfStat); err != nil {
+			return entered, &NamespaceError{Type: nsType, Err: fmt.Errorf("failed to stat self namespace: %w", err)}
+		}
+		if err := syscall.Stat(targetPath, &targetStat); err != nil {
+			return entered, &NamespaceError{Type: nsType, Err: fmt.Errorf("failed to stat target namespace: %w", err)}
+		}
+
+		// Already in the same namespace
+		if selfStat.Ino == targetStat.Ino {
+			continue
+		}
+
+		// Keep the original namespace open to switch back later
+		orig, err := unix.Open(selfPath, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return entered, &NamespaceError{Type: nsType, Err: fmt.Errorf("failed to open namespace: %w", err)}
+		}
+
+		// 使用 unix.O_CLOEXEC 确保文件描述符不会在 exec 时泄露
+		fd, err := unix.Open(targetPath, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			unix.Close(orig)
+			return entered, &NamespaceError{Type: nsType, Err: fmt.Errorf("failed to open namespace: %w", err)}
+		}
+
+		// setns into a mount namespace is refused while the thread shares
+		// its root and working directory with other threads
+		if nsType == "mnt" {
+			*tainted = true
+			if err := unix.Unshare(unix.CLONE_FS); err != nil {
+				unix.Close(fd)
+				unix.Close(orig)
+				return entered, &NamespaceError{Type: nsType, Err: fmt.Errorf("unshare failed: %w", err)}
+			}
+		}
+
+		err = unix.Setns(fd, 0)
+		unix.Close(fd)
+		if err != nil {
+			unix.Close(orig)
+			return entered, &NamespaceError{Type: nsType, Err: fmt.Errorf("setns failed: %w", err)}
+		}
 
-	// Switch to the namespace using unix.Setns
-	// 替代原有的 syscall.Syscall(syscall.SYS_SETNS, ...)
-	if err := unix.Setns(fd, 0); err != nil {
-		return -1, fmt.Errorf("setns failed: %w", err)
+		entered = append(entered, orig)
 	}
 
-	return 1, nil
+	return entered, nil
 }
@@ -7,8 +7,12 @@
 
 package process
 
-// EnterNamespace is a no-op on non-Linux platforms
-// Returns 0 (no switch needed)
-func EnterNamespace(pid int, nsType string) (int, error) {
-	return 0, nil
+// SameNamespace always reports true on non-Linux platforms
+func SameNamespace(procRoot string, pid int, nsType string) bool {
+	return true
+}
+
+// WithNamespaces just runs fn on non-Linux platforms
+func WithNamespaces(procRoot string, pid int, nsTypes []string, fn func() error) error {
+	return fn()
 }
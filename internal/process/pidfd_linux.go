@@ -0,0 +1,69 @@
+//go:build linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPidfd returns a pidfd for the process (Linux 5.3+). Unlike the PID,
+// it keeps referring to the same process after that process exits, so
+// signals sent through it can never reach a process that reused the PID
+// pidfds are always close-on-exec
+func OpenPidfd(pid int) (int, error) {
+	return unix.PidfdOpen(pid, 0)
+}
+
+// SignalPidfd sends sig to the process of a pidfd. Signal 0 checks that
+// the process is still running
+func SignalPidfd(pidfd int, sig syscall.Signal) error {
+	return unix.PidfdSendSignal(pidfd, sig, nil, 0)
+}
+
+// ClosePidfd releases a pidfd
+func ClosePidfd(pidfd int) {
+	unix.Close(pidfd)
+}
+
+// LocalProcfs reports whether procRoot is the procfs of the PID namespace
+// of the current process, whose PIDs can be signaled and pinned with a
+// pidfd. The host procfs seen from a container lists the PIDs of another
+// namespace, where the current process has another PID or none
+func LocalProcfs(procRoot string) bool {
+	self, err := os.Readlink(procPath(procRoot, "self"))
+	return err == nil && self == strconv.Itoa(os.Getpid())
+}
+
+// StartTime returns the start time of the process in clock ticks since
+// boot, field 22 of /proc/[pid]/stat. Together with the PID it identifies
+// the process across PID reuse
+func StartTime(procRoot string, pid int) (uint64, error) {
+	data, err := os.ReadFile(procPath(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+
+	// The command name in parentheses may contain spaces and parentheses,
+	// the fields after it start with the state (field 3)
+	stat := string(data)
+	idx := strings.LastIndexByte(stat, ')')
+	if idx == -1 {
+		return 0, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
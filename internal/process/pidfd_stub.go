@@ -0,0 +1,41 @@
+//go:build !linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package process
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errNoPidfd is returned on platforms without pidfds
+var errNoPidfd = errors.New("pidfd is not supported on this platform")
+
+// OpenPidfd is not supported on non-Linux platforms
+func OpenPidfd(pid int) (int, error) {
+	return -1, errNoPidfd
+}
+
+// SignalPidfd is not supported on non-Linux platforms
+func SignalPidfd(pidfd int, sig syscall.Signal) error {
+	return errNoPidfd
+}
+
+// ClosePidfd does nothing on non-Linux platforms
+func ClosePidfd(pidfd int) {}
+
+// LocalProcfs returns true on non-Linux platforms, which have no PID
+// namespaces
+func LocalProcfs(procRoot string) bool {
+	return true
+}
+
+// StartTime returns 0 on non-Linux platforms, where the start time is not
+// part of the process identity
+func StartTime(procRoot string, pid int) (uint64, error) {
+	return 0, nil
+}
@@ -6,13 +6,140 @@
 package protocol
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"syscall"
+	"time"
+
+	"github.com/xxs-2/jattach-go/internal/process"
 )
 
+// Target identifies the JVM process to attach to
+type Target struct {
+	PID        int    // Host PID, used for signals
+	NsPID      int    // PID inside the container namespace
+	TmpPath    string // Directory holding the attach files
+	MntChanged int    // Nonzero if the mount namespace was switched
+	ProcRoot   string // Host procfs mount, empty for /proc
+
+	// PidFD pins the process for signals and liveness checks, or is -1
+	// where pidfds are not available. StartTime then guards against a
+	// reused PID instead, unless it is zero
+	PidFD     int
+	StartTime uint64
+
+	// Foreign is set when PID belongs to another PID namespace than ours,
+	// as with a host procfs mounted in a container. Such a process cannot
+	// be signaled, and is only known through ProcRoot
+	Foreign bool
+}
+
+// procEntry returns where the target is found in procfs: under its own
+// PID in the container's /proc once its mount namespace was entered, since
+// the host procfs is only reachable from ours, under ProcRoot otherwise
+func (t *Target) procEntry() (string, int) {
+	if t.MntChanged > 0 {
+		return "/proc", t.NsPID
+	}
+	if t.ProcRoot == "" {
+		return "/proc", t.PID
+	}
+	return t.ProcRoot, t.PID
+}
+
+var (
+	// ErrNotJVM is returned instead of signaling a process that shows no
+	// sign of being a JVM
+	ErrNotJVM = errors.New("target is not a JVM")
+
+	// ErrProcessGone is returned instead of signaling a target that
+	// exited, including when its PID now belongs to another process
+	ErrProcessGone = errors.New("process exited")
+
+	// ErrForeignPID is returned instead of signaling a target of another
+	// PID namespace, whose PID would name another process in ours
+	ErrForeignPID = errors.New("process is in another PID namespace and cannot be signaled")
+
+	// ErrQuitNotHandled is returned instead of signaling a JVM that does
+	// not handle SIGQUIT, because of -Xrs or because it is still starting
+	ErrQuitNotHandled = errors.New("SIGQUIT is not handled by the JVM")
+)
+
+// Options controls the behavior of an attach sequence
+type Options struct {
+	// PrintOutput prints progress and the JVM response to stdout
+	PrintOutput bool
+
+	// Timeout bounds each phase up to and including the command write
+	Timeout time.Duration
+
+	// ReadTimeout bounds the wait for the response, zero or negative means
+	// no limit
+	ReadTimeout time.Duration
+
+	// Timings, if set, receives the duration of each phase
+	Timings *[]Timing
+
+	// ReplyAddress is the IP address the OpenJ9 reply listener binds to,
+	// empty for the loopback interface
+	ReplyAddress string
+
+	// Logf, if set, receives diagnostic messages
+	Logf func(format string, v ...interface{})
+
+	// SignalHook, if set, sends the attach signal instead of kill(2)
+	SignalHook func(pid int, sig syscall.Signal) error
+}
+
+// Timing is the duration of one attach phase
+type Timing struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// record adds the time since start to the timings as phase, and returns
+// the start of the next phase
+func (o *Options) record(phase string, start time.Time) time.Time {
+	now := time.Now()
+	if o.Timings != nil {
+		*o.Timings = append(*o.Timings, Timing{Phase: phase, Duration: now.Sub(start)})
+	}
+	return now
+}
+
+// logf passes a diagnostic message to Logf if set
+func (o *Options) logf(format string, v ...interface{}) {
+	if o.Logf != nil {
+		o.Logf(format, v...)
+	}
+}
+
+// TimeoutError reports the attach phase that ran out of time
+type TimeoutError struct {
+	Phase string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout in %s", e.Phase)
+}
+
+// SecurityError reports an attach endpoint that cannot be trusted to be the
+// target JVM, e.g. a socket planted in a shared /tmp by another user
+type SecurityError struct {
+	Path   string
+	Reason string
+}
+
+func (e *SecurityError) Error() string {
+	return fmt.Sprintf("refusing %s: %s", e.Path, e.Reason)
+}
+
 // IsOpenJ9Process checks if the target process is an OpenJ9 JVM
 // by looking for the attachInfo file
 func IsOpenJ9Process(tmpPath string, pid int) bool {
@@ -21,29 +148,59 @@ func IsOpenJ9Process(tmpPath string, pid int) bool {
 	return syscall.Stat(attachInfoPath, &st) == nil
 }
 
-// getFileOwner returns the owner UID of a file
-func getFileOwner(path string) (uint32, error) {
-	var st syscall.Stat_t
-	if err := syscall.Stat(path, &st); err != nil {
-		return 0, err
+// IsHotSpotListening checks if the HotSpot attach listener of the target
+// process is already running, i.e. its .java_pid socket exists
+func IsHotSpotListening(tmpPath string, pid int) bool {
+	return checkSocket(filepath.Join(tmpPath, fmt.Sprintf(".java_pid%d", pid)))
+}
+
+// signalProcess sends sig to the target through its pidfd, or through hook
+// if set. Without a pidfd the start time is checked right before kill(2),
+// which leaves a much smaller window for PID reuse
+func signalProcess(t *Target, sig syscall.Signal, hook func(pid int, sig syscall.Signal) error) error {
+	if t.Foreign {
+		return ErrForeignPID
 	}
-	return st.Uid, nil
+	if hook != nil {
+		return hook(t.PID, sig)
+	}
+
+	var err error
+	if t.PidFD >= 0 {
+		err = process.SignalPidfd(t.PidFD, sig)
+	} else if !t.sameProcess() {
+		return ErrProcessGone
+	} else {
+		err = syscall.Kill(t.PID, sig)
+	}
+	if errors.Is(err, syscall.ESRCH) {
+		return ErrProcessGone
+	}
+	return err
 }
 
-// processIsAlive checks if a process is still running
-func processIsAlive(pid int) bool {
-	// Send signal 0 to check if process exists
-	err := syscall.Kill(pid, syscall.Signal(0))
-	return err == nil
+// sameProcess checks that the PID of the target still belongs to the
+// process it was resolved to
+func (t *Target) sameProcess() bool {
+	if t.StartTime == 0 {
+		return true
+	}
+	procRoot, pid := t.procEntry()
+	startTime, err := process.StartTime(procRoot, pid)
+	return err == nil && startTime == t.StartTime
 }
 
-// checkFileOwnership verifies that the file is owned by the current effective user
-func checkFileOwnership(path string) bool {
-	owner, err := getFileOwner(path)
-	if err != nil {
-		return false
+// processIsAlive checks if the target is still running
+func processIsAlive(t *Target) bool {
+	// Send signal 0 to check if process exists
+	if t.PidFD >= 0 {
+		return process.SignalPidfd(t.PidFD, syscall.Signal(0)) == nil
+	}
+	if t.Foreign {
+		// Only procfs knows the process
+		return t.sameProcess()
 	}
-	return owner == uint32(os.Geteuid())
+	return syscall.Kill(t.PID, syscall.Signal(0)) == nil && t.sameProcess()
 }
 
 // Response wraps a JVM response
@@ -52,6 +209,60 @@ type Response struct {
 	Output string
 }
 
+// Stream wraps a JVM response whose body has not been read yet
+type Stream struct {
+	Code int
+	Body io.ReadCloser
+
+	// head holds the raw status line(s) consumed while parsing Code
+	head string
+}
+
+// phaseDeadline returns the deadline for one attach phase: the given
+// timeout from now, or the context deadline if that comes first
+func phaseDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return deadline
+}
+
+// watchContext interrupts pending I/O on conn when ctx is done
+// The returned function stops watching
+func watchContext(ctx context.Context, conn net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+// phaseError converts an error from the given phase into a TimeoutError
+// if a deadline caused it, or into the context error on cancellation
+func phaseError(ctx context.Context, phase string, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Phase: phase}
+	}
+	return err
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // formatError creates an error with context
 func formatError(format string, args ...interface{}) error {
 	return fmt.Errorf(format, args...)
@@ -0,0 +1,54 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestForeignTargetLiveness(t *testing.T) {
+	const pid = 4194000
+	if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+		t.Skipf("PID %d exists", pid)
+	}
+
+	procRoot := t.TempDir()
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	setStartTime := func(startTime int) {
+		stat := fmt.Sprintf("%d (java) S 1 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 30 0 %d 0 0\n", pid, startTime)
+		if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setStartTime(12345)
+
+	// The PID does not exist in our namespace, only procRoot knows it
+	target := &Target{PID: pid, NsPID: 7, ProcRoot: procRoot, PidFD: -1, StartTime: 12345, Foreign: true}
+	if !processIsAlive(target) {
+		t.Error("running process reported dead")
+	}
+	if err := signalProcess(target, syscall.SIGQUIT, nil); !errors.Is(err, ErrForeignPID) {
+		t.Errorf("signalProcess() = %v, want ErrForeignPID", err)
+	}
+
+	setStartTime(99999)
+	if processIsAlive(target) {
+		t.Error("reused PID reported as the target")
+	}
+
+	os.RemoveAll(dir)
+	if processIsAlive(target) {
+		t.Error("exited process reported alive")
+	}
+}
@@ -6,6 +6,7 @@
 package protocol
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -17,43 +18,133 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/xxs-2/jattach-go/internal/process"
 )
 
 // AttachHotSpot performs the HotSpot/OpenJDK attach sequence
-func AttachHotSpot(ctx context.Context, pid, nspid int, tmpPath string, mntChanged int, cmd string, args []string, printOutput bool) (*Response, error) {
-	socketPath := filepath.Join(tmpPath, fmt.Sprintf(".java_pid%d", nspid))
+// The whole response is read until the JVM closes the connection. With
+// PrintOutput it is printed as it arrives, so that a long running command
+// shows its progress
+func AttachHotSpot(ctx context.Context, t *Target, cmd string, args []string, opts *Options) (*Response, error) {
+	stream, err := OpenHotSpot(ctx, t, cmd, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Body.Close()
+
+	var body bytes.Buffer
+	var w io.Writer = &body
+	if opts.PrintOutput {
+		fmt.Print("JVM response code = ")
+		fmt.Print(stream.head)
+		w = io.MultiWriter(&body, os.Stdout)
+	}
+
+	start := time.Now()
+	if _, err := io.Copy(w, stream.Body); err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	opts.record("read_body", start)
+	output := stream.head + body.String()
+
+	// Print error message if load failed
+	if cmd == "load" && stream.Code != 0 && !opts.PrintOutput {
+		if strings.Contains(stream.head, "\n") {
+			fmt.Fprint(os.Stderr, body.String())
+		} else if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "Target JVM failed to load %s\n", args[0])
+		}
+	}
+
+	if opts.PrintOutput && cmd != "load" {
+		fmt.Println()
+	}
+
+	return &Response{
+		Code:   stream.Code,
+		Output: output,
+	}, nil
+}
+
+// OpenHotSpot performs the HotSpot/OpenJDK attach sequence and returns as soon
+// as the return code has been read. The rest of the response is left in
+// Stream.Body, which the caller must close
+func OpenHotSpot(ctx context.Context, t *Target, cmd string, args []string, opts *Options) (*Stream, error) {
+	socketPath := filepath.Join(t.TmpPath, fmt.Sprintf(".java_pid%d", t.NsPID))
+	start := time.Now()
 
 	// Check if socket already exists
 	if !checkSocket(socketPath) {
 		// Start attach mechanism (create trigger file + SIGQUIT + wait)
-		if err := startAttachMechanism(ctx, pid, nspid, tmpPath, mntChanged, socketPath); err != nil {
+		if err := startAttachMechanism(ctx, t, socketPath, opts); err != nil {
 			return nil, fmt.Errorf("could not start attach mechanism: %w", err)
 		}
+		start = opts.record("wait_socket", start)
 	}
 
 	// Connect to Unix domain socket
-	conn, err := net.Dial("unix", socketPath)
+	if err := checkSocketFile(socketPath); err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Deadline: phaseDeadline(ctx, opts.Timeout)}
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to socket: %w", err)
+		return nil, fmt.Errorf("could not connect to socket: %w", phaseError(ctx, "connect", err))
 	}
-	defer conn.Close()
+	if err := checkPeer(conn, socketPath, t); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	stop := watchContext(ctx, conn)
+	start = opts.record("connect", start)
 
-	if printOutput {
+	if opts.PrintOutput {
 		fmt.Println("Connected to remote JVM")
 	}
 
 	// Write command
+	conn.SetWriteDeadline(phaseDeadline(ctx, opts.Timeout))
 	if err := writeCommand(conn, cmd, args); err != nil {
-		return nil, fmt.Errorf("error writing command: %w", err)
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("error writing command: %w", phaseError(ctx, "write_command", err))
 	}
+	start = opts.record("write_command", start)
 
-	// Read response
-	resp, err := readResponse(conn, cmd, args, printOutput)
+	// Read return code, leave the body on the socket
+	conn.SetReadDeadline(phaseDeadline(ctx, opts.ReadTimeout))
+	stream, err := readResponse(ctx, conn, cmd, stop)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("error reading response: %w", phaseError(ctx, "read_response", err))
+	}
+	opts.record("read_response", start)
+
+	return stream, nil
+}
+
+// checkSocketFile applies the checks of the JDK's VirtualMachineImpl to the
+// attach socket: it must be owned by the current user and group, which are
+// those of the target, and be accessible by the owner only. root may
+// attach to sockets of any owner
+func checkSocketFile(path string) error {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return fmt.Errorf("could not stat socket: %w", err)
 	}
 
-	return resp, nil
+	uid, gid := os.Geteuid(), os.Getegid()
+	switch {
+	case uid != 0 && int(st.Uid) != uid:
+		return &SecurityError{Path: path, Reason: fmt.Sprintf("owned by uid %d, not %d", st.Uid, uid)}
+	case uid != 0 && int(st.Gid) != gid:
+		return &SecurityError{Path: path, Reason: fmt.Sprintf("owned by gid %d, not %d", st.Gid, gid)}
+	case st.Mode&0066 != 0:
+		return &SecurityError{Path: path, Reason: fmt.Sprintf("accessible by group or others (mode %#o)", st.Mode&0777)}
+	}
+	return nil
 }
 
 // checkSocket verifies that a socket file exists and is actually a socket
@@ -65,77 +156,123 @@ func checkSocket(path string) bool {
 	return info.Mode()&os.ModeSocket != 0
 }
 
-// startAttachMechanism triggers the JVM attach listener
-// Creates .attach_pid file, sends SIGQUIT, and polls for socket
-func startAttachMechanism(ctx context.Context, pid, nspid int, tmpPath string, mntChanged int, socketPath string) error {
-	// Determine attach trigger file path
-	var attachPath string
-	if mntChanged > 0 {
-		attachPath = filepath.Join("/proc", strconv.Itoa(nspid), "cwd", fmt.Sprintf(".attach_pid%d", nspid))
-	} else {
-		attachPath = filepath.Join("/proc", strconv.Itoa(pid), "cwd", fmt.Sprintf(".attach_pid%d", nspid))
+// checkJVM makes sure SIGQUIT will start the attach listener of the target
+// rather than kill it. A JVM may be recognized by its hsperfdata file when
+// its libraries cannot be inspected
+func checkJVM(t *Target, procRoot string, pid int) error {
+	if !process.IsJVM(procRoot, pid) {
+		if _, err := process.FindPerfData(t.TmpPath, t.NsPID); err != nil {
+			return ErrNotJVM
+		}
 	}
 
-	// Create attach trigger file
-	f, err := os.OpenFile(attachPath, os.O_CREATE|os.O_WRONLY, 0660)
-	useFallback := false
+	if process.ReducesSignals(procRoot, pid) {
+		return ErrQuitNotHandled
+	}
+	if handled, err := process.HandlesSignal(procRoot, pid, syscall.SIGQUIT); err == nil && !handled {
+		return ErrQuitNotHandled
+	}
+	return nil
+}
 
-	if err != nil || !checkFileOwnership(attachPath) {
-		// Some mounted filesystems may change the ownership of the file.
-		// JVM will not trust such file, so try a different path
-		os.Remove(attachPath)
-		useFallback = true
+// createTrigger creates the attach trigger file name in the directory at
+// path. Some mounted filesystems may change the ownership of the file,
+// which the JVM will not trust, so with checkOwner such a file is removed
+// and an error returned
+func createTrigger(path, name string, checkOwner bool) (*artifact, error) {
+	dir, err := openDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDir(dir); err != nil {
+		dir.Close()
+		return nil, err
 	}
 
-	if useFallback {
-		// Fallback to /tmp
-		attachPath = filepath.Join(tmpPath, fmt.Sprintf(".attach_pid%d", nspid))
-		f, err = os.OpenFile(attachPath, os.O_CREATE|os.O_WRONLY, 0660)
-		if err != nil {
-			return fmt.Errorf("failed to create attach trigger file: %w", err)
-		}
+	f, err := createFile(dir, name, 0660)
+	if err != nil {
+		dir.Close()
+		return nil, err
 	}
+	trigger := &artifact{dir: dir, name: name}
 
+	var st syscall.Stat_t
+	err = syscall.Fstat(int(f.Fd()), &st)
 	f.Close()
-	defer os.Remove(attachPath)
+	if checkOwner && (err != nil || int(st.Uid) != os.Geteuid()) {
+		trigger.remove()
+		return nil, fmt.Errorf("%s/%s is not owned by uid %d", path, name, os.Geteuid())
+	}
+	return trigger, nil
+}
+
+// createAttachTrigger creates the attach trigger file in the working
+// directory of the JVM, or in its temporary directory if that fails
+func createAttachTrigger(t *Target, procRoot string, pid int) (*artifact, error) {
+	name := fmt.Sprintf(".attach_pid%d", t.NsPID)
+	trigger, err := createTrigger(filepath.Join(procRoot, strconv.Itoa(pid), "cwd"), name, true)
+	if err != nil {
+		// Fallback to /tmp
+		trigger, err = createTrigger(t.TmpPath, name, false)
+	}
+	return trigger, err
+}
+
+// startAttachMechanism triggers the JVM attach listener
+// Creates .attach_pid file, sends SIGQUIT, and polls for socket
+func startAttachMechanism(ctx context.Context, t *Target, socketPath string, opts *Options) error {
+	procRoot, pid := t.procEntry()
+
+	// SIGQUIT terminates processes that do not handle it
+	if err := checkJVM(t, procRoot, pid); err != nil {
+		return err
+	}
+
+	trigger, err := createAttachTrigger(t, procRoot, pid)
+	if err != nil {
+		return fmt.Errorf("failed to create attach trigger file: %w", err)
+	}
+	defer trigger.remove()
 
 	// Send SIGQUIT to trigger attach listener (use host PID, not namespace PID)
-	if err := syscall.Kill(pid, syscall.SIGQUIT); err != nil {
+	if err := signalProcess(t, syscall.SIGQUIT, opts.SignalHook); err != nil {
 		return fmt.Errorf("failed to send SIGQUIT: %w", err)
 	}
 
 	// Poll for socket with exponential backoff
 	delay := 20 * time.Millisecond
 	maxDelay := 500 * time.Millisecond
-	timeout := 6 * time.Second
-	deadline := time.Now().Add(timeout)
-
-	for time.Now().Before(deadline) {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	deadline := phaseDeadline(ctx, opts.Timeout)
 
+	for {
 		// Check if socket appeared
 		if checkSocket(socketPath) {
 			return nil
 		}
 
 		// Check if process is still alive
-		if !processIsAlive(pid) {
-			return fmt.Errorf("process %d died while waiting for attach", pid)
+		if !processIsAlive(t) {
+			return fmt.Errorf("process %d died while waiting for attach", t.PID)
+		}
+
+		remaining := time.Until(deadline)
+		if !deadline.IsZero() && remaining <= 0 {
+			return &TimeoutError{Phase: "wait_socket"}
 		}
 
 		// Sleep with exponential backoff
-		time.Sleep(delay)
+		sleep := delay
+		if !deadline.IsZero() && sleep > remaining {
+			sleep = remaining
+		}
+		if err := sleepContext(ctx, sleep); err != nil {
+			return phaseError(ctx, "wait_socket", err)
+		}
 		delay += 20 * time.Millisecond
 		if delay > maxDelay {
 			delay = maxDelay
 		}
 	}
-
-	return fmt.Errorf("timeout waiting for socket to appear")
 }
 
 // writeCommand sends a command to the JVM via the socket
@@ -176,98 +313,84 @@ func writeCommand(conn net.Conn, cmd string, args []string) error {
 	return err
 }
 
-// readResponse reads and parses the JVM response
+// readResponse reads the return code line of the JVM response
 // Special handling for 'load' command to extract Agent_OnAttach result
-func readResponse(conn net.Conn, cmd string, args []string, printOutput bool) (*Response, error) {
-	buf := make([]byte, 8192)
-	n, err := conn.Read(buf)
+func readResponse(ctx context.Context, conn net.Conn, cmd string, stop func() bool) (*Stream, error) {
+	r := bufio.NewReader(conn)
+	head, err := r.ReadString('\n')
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("error reading response: %w", err)
+		return nil, err
 	}
-	if n == 0 {
+	if len(head) == 0 {
 		return nil, fmt.Errorf("unexpected EOF reading response")
 	}
 
-	data := buf[:n]
-
 	// Parse return code from first line
-	code := 0
-	lines := bytes.SplitN(data, []byte{'\n'}, 2)
-	if len(lines) > 0 {
-		codeStr := string(bytes.TrimSpace(lines[0]))
-		code, _ = strconv.Atoi(codeStr)
-	}
-
-	// Special handling for 'load' command
-	if cmd == "load" {
-		// Read all remaining data
-		remaining := &bytes.Buffer{}
-		remaining.Write(data)
-		io.Copy(remaining, conn)
-
-		output := remaining.String()
-
-		// Parse Agent_OnAttach return code
-		if code == 0 && len(output) >= 2 {
-			secondLine := ""
-			if lines := strings.SplitN(output, "\n", 3); len(lines) >= 2 {
-				secondLine = strings.TrimSpace(lines[1])
-			}
-
-			if strings.HasPrefix(secondLine, "return code: ") {
-				// JDK 9+: Agent_OnAttach result after "return code: "
-				codeStr := strings.TrimSpace(secondLine[13:])
-				code, _ = strconv.Atoi(codeStr)
-			} else if len(secondLine) > 0 && (secondLine[0] >= '0' && secondLine[0] <= '9' || secondLine[0] == '-') {
-				// JDK 8: Agent_OnAttach result on second line alone
-				code, _ = strconv.Atoi(secondLine)
-			} else if len(secondLine) > 0 {
-				// JDK 21+: load command always returns 0; rest is error message
-				code = -1
-			}
-		}
-
-		// Print error message if load failed
-		if code != 0 && !printOutput {
-			if len(lines) >= 2 {
-				fmt.Fprint(os.Stderr, string(lines[1]))
-			} else if len(args) > 0 {
-				fmt.Fprintf(os.Stderr, "Target JVM failed to load %s\n", args[0])
-			}
-		}
-
-		if printOutput {
-			fmt.Print("JVM response code = ")
-			fmt.Print(output)
-		}
+	code, _ := strconv.Atoi(strings.TrimSpace(head))
 
-		return &Response{
-			Code:   code,
-			Output: output,
+	if cmd != "load" {
+		return &Stream{
+			Code: code,
+			Body: &connReader{Reader: r, conn: conn, ctx: ctx, stop: stop},
+			head: head,
 		}, nil
 	}
 
-	// For other commands, just read remaining data
-	if printOutput {
-		fmt.Print("JVM response code = ")
-		fmt.Print(string(data))
-
-		// Read and print remaining output
-		buf2 := make([]byte, 8192)
-		for {
-			n, err := conn.Read(buf2)
-			if n > 0 {
-				fmt.Print(string(buf2[:n]))
-			}
-			if err != nil {
-				break
-			}
+	// The load response is short and its real result follows the first
+	// line, so read it completely
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	stop()
+	conn.Close()
+
+	// Parse Agent_OnAttach return code
+	if code == 0 && len(head)+len(rest) >= 2 {
+		secondLine := string(rest)
+		if idx := strings.IndexByte(secondLine, '\n'); idx != -1 {
+			secondLine = secondLine[:idx]
+		}
+		secondLine = strings.TrimSpace(secondLine)
+
+		if strings.HasPrefix(secondLine, "return code: ") {
+			// JDK 9+: Agent_OnAttach result after "return code: "
+			codeStr := strings.TrimSpace(secondLine[13:])
+			code, _ = strconv.Atoi(codeStr)
+		} else if len(secondLine) > 0 && (secondLine[0] >= '0' && secondLine[0] <= '9' || secondLine[0] == '-') {
+			// JDK 8: Agent_OnAttach result on second line alone
+			code, _ = strconv.Atoi(secondLine)
+		} else if len(secondLine) > 0 {
+			// JDK 21+: load command always returns 0; rest is error message
+			code = -1
 		}
-		fmt.Println()
 	}
 
-	return &Response{
-		Code:   code,
-		Output: string(data),
+	return &Stream{
+		Code: code,
+		Body: io.NopCloser(bytes.NewReader(rest)),
+		head: head,
 	}, nil
 }
+
+// connReader reads the buffered remainder of a response and closes the
+// underlying connection when done
+type connReader struct {
+	*bufio.Reader
+	conn net.Conn
+	ctx  context.Context
+	stop func() bool
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = phaseError(r.ctx, "read_response", err)
+	}
+	return n, err
+}
+
+func (r *connReader) Close() error {
+	r.stop()
+	return r.conn.Close()
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// startSleeper runs a process in dir until the test ends
+func startSleeper(t *testing.T, dir string) int {
+	t.Helper()
+	cmd := exec.Command("sleep", "60")
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd.Process.Pid
+}
+
+func TestCreateAttachTriggerInWorkingDirectory(t *testing.T) {
+	cwd, tmp := t.TempDir(), t.TempDir()
+	pid := startSleeper(t, cwd)
+	target := &Target{PID: pid, NsPID: pid, TmpPath: tmp}
+	name := fmt.Sprintf(".attach_pid%d", pid)
+
+	trigger, err := createAttachTrigger(target, "/proc", pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(cwd, name)); err != nil {
+		t.Errorf("trigger not created in the working directory: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, name)); err == nil {
+		t.Errorf("trigger created in the temporary directory")
+	}
+
+	trigger.remove()
+	if _, err := os.Lstat(filepath.Join(cwd, name)); !os.IsNotExist(err) {
+		t.Errorf("trigger not removed: %v", err)
+	}
+}
+
+func TestCreateAttachTriggerRefusesSymlink(t *testing.T) {
+	cwd, tmp := t.TempDir(), t.TempDir()
+	pid := startSleeper(t, cwd)
+	target := &Target{PID: pid, NsPID: pid, TmpPath: tmp}
+	name := fmt.Sprintf(".attach_pid%d", pid)
+	victim := filepath.Join(t.TempDir(), "victim")
+	for _, dir := range []string{cwd, tmp} {
+		if err := os.Symlink(victim, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := createAttachTrigger(target, "/proc", pid)
+	var secErr *SecurityError
+	if !errors.As(err, &secErr) {
+		t.Fatalf("got %v, want a SecurityError", err)
+	}
+	if _, err := os.Lstat(victim); !os.IsNotExist(err) {
+		t.Errorf("symlink followed to %s", victim)
+	}
+}
+
+func TestCreateTriggerRefusesSymlinkedTmp(t *testing.T) {
+	// A fake procfs whose <pid>/root is the container's filesystem, where
+	// tmp is an absolute symlink meant to reach the attacher's victim
+	// directory
+	procRoot, container, victim := t.TempDir(), t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(procRoot, "42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(container, filepath.Join(procRoot, "42", "root")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(container, "tmp")); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpPath := filepath.Join(procRoot, "42", "root", "tmp")
+	if trigger, err := createTrigger(tmpPath, ".attach_pid42", false); err == nil {
+		trigger.remove()
+		t.Fatal("trigger created through a symlinked tmp")
+	}
+	if _, err := openAttachDir(tmpPath, true); err == nil {
+		t.Fatal("OpenJ9 attach directory opened through a symlinked tmp")
+	}
+	if entries, _ := os.ReadDir(victim); len(entries) != 0 {
+		t.Errorf("symlink followed to %s: %v", victim, entries)
+	}
+}
+
+func TestSplitProcRoot(t *testing.T) {
+	tests := []struct {
+		path, root, rel string
+	}{
+		{"/proc/42/root/tmp", "/proc/42/root", "tmp"},
+		{"/host/proc/42/root/var/tmp/", "/host/proc/42/root", "var/tmp"},
+		{"/proc/42/cwd", "/proc/42/cwd", ""},
+		{"/tmp", "/tmp", ""},
+	}
+	for _, tt := range tests {
+		if root, rel := splitProcRoot(tt.path); root != tt.root || rel != tt.rel {
+			t.Errorf("splitProcRoot(%q) = %q, %q, want %q, %q", tt.path, root, rel, tt.root, tt.rel)
+		}
+	}
+}
@@ -8,12 +8,12 @@ package protocol
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -23,16 +23,50 @@ import (
 const maxNotifFiles = 256
 
 // AttachOpenJ9 performs the OpenJ9 attach sequence
-func AttachOpenJ9(ctx context.Context, pid, nspid int, tmpPath string, cmd string, args []string, printOutput bool) (*Response, error) {
+func AttachOpenJ9(ctx context.Context, t *Target, cmd string, args []string, opts *Options) (*Response, error) {
+	session, err := ConnectOpenJ9(ctx, t, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := session.Do(ctx, cmd, args, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detach cleanly
+	if resp.Code != 1 {
+		start := time.Now()
+		session.Close(ctx, opts)
+		opts.record("detach", start)
+	} else {
+		session.conn.Close()
+	}
+
+	return resp, nil
+}
+
+// OpenJ9Session is a connection to an OpenJ9 JVM that completed the attach
+// handshake. Commands run on it one at a time until Close detaches
+type OpenJ9Session struct {
+	conn net.Conn
+}
+
+// ConnectOpenJ9 performs the OpenJ9 attach handshake: global attach lock,
+// replyInfo file, semaphore notification and authenticated accept
+// The lock and the notification are released once the JVM has connected
+func ConnectOpenJ9(ctx context.Context, t *Target, opts *Options) (*OpenJ9Session, error) {
 	// Acquire global attach lock
-	attachLock, err := acquireLock(tmpPath, "", "_attachlock")
+	start := time.Now()
+	attachLock, err := acquireLockContext(ctx, t.TmpPath, "", "_attachlock", opts.Timeout)
 	if err != nil {
 		return nil, fmt.Errorf("could not acquire attach lock: %w", err)
 	}
 	defer releaseLock(attachLock)
+	start = opts.record("attach_lock", start)
 
 	// Create listening TCP socket
-	listener, port, err := createAttachSocket()
+	listener, err := listenReply(opts.ReplyAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create attach socket: %w", err)
 	}
@@ -42,75 +76,141 @@ func AttachOpenJ9(ctx context.Context, pid, nspid int, tmpPath string, cmd strin
 	key := randomKey()
 
 	// Write replyInfo file with key and port
-	replyInfoPath := filepath.Join(tmpPath, ".com_ibm_tools_attach", strconv.Itoa(nspid), "replyInfo")
-	if err := writeReplyInfo(replyInfoPath, port, key); err != nil {
+	replyInfo, err := writeReplyInfo(t.TmpPath, t.NsPID, listener.port, key)
+	if err != nil {
 		return nil, fmt.Errorf("could not write replyInfo: %w", err)
 	}
-	defer os.Remove(replyInfoPath)
+	defer replyInfo.remove()
 
 	// Lock notification files
-	notifLocks, notifCount := lockNotificationFiles(tmpPath)
+	notifLocks, notifCount := lockNotificationFiles(t.TmpPath)
 	defer unlockNotificationFiles(notifLocks, notifCount)
 
 	// Notify semaphore to wake JVM threads
-	if err := notifySemaphore(tmpPath, 1, notifCount); err != nil {
+	if err := notifySemaphore(t.TmpPath, 1, notifCount); err != nil {
 		// Not fatal, continue
-		if printOutput {
+		if opts.PrintOutput {
 			fmt.Printf("Warning: failed to notify semaphore: %v\n", err)
 		}
 	}
-	defer notifySemaphore(tmpPath, -1, notifCount)
+	defer notifySemaphore(t.TmpPath, -1, notifCount)
 
 	// Accept connection from JVM with timeout
-	conn, err := acceptClient(listener, key)
+	conn, err := acceptClient(ctx, listener, key, phaseDeadline(ctx, opts.Timeout), opts)
 	if err != nil {
 		return nil, fmt.Errorf("JVM did not connect: %w", err)
 	}
-	defer conn.Close()
+	opts.record("accept", start)
 
-	if printOutput {
+	if opts.PrintOutput {
 		fmt.Println("Connected to remote JVM")
 	}
 
+	return &OpenJ9Session{conn: conn}, nil
+}
+
+// Do sends a command and reads its response. If it fails the connection
+// is closed, since the JVM may still be sending the response
+func (s *OpenJ9Session) Do(ctx context.Context, cmd string, args []string, opts *Options) (*Response, error) {
+	stop := watchContext(ctx, s.conn)
+	defer stop()
+	start := time.Now()
+
 	// Translate and send command
 	translatedCmd := TranslateCommand(cmd, args)
-	if err := writeCommandOpenJ9(conn, translatedCmd); err != nil {
-		return nil, fmt.Errorf("error writing command: %w", err)
+	s.conn.SetWriteDeadline(phaseDeadline(ctx, opts.Timeout))
+	if err := writeCommandOpenJ9(s.conn, translatedCmd); err != nil {
+		s.conn.Close()
+		return nil, fmt.Errorf("error writing command: %w", phaseError(ctx, "write_command", err))
 	}
+	start = opts.record("write_command", start)
 
 	// Read response
-	resp, err := readResponseOpenJ9(conn, translatedCmd, printOutput)
+	s.conn.SetReadDeadline(phaseDeadline(ctx, opts.ReadTimeout))
+	resp, err := readResponseOpenJ9(s.conn, translatedCmd, opts.PrintOutput)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
-	}
-
-	// Detach cleanly
-	if resp.Code != 1 {
-		detach(conn)
+		s.conn.Close()
+		return nil, fmt.Errorf("error reading response: %w", phaseError(ctx, "read_response", err))
 	}
+	opts.record("read_response", start)
 
 	return resp, nil
 }
 
-// acquireLock acquires a file lock
-func acquireLock(tmpPath, subdir, filename string) (*os.File, error) {
-	lockPath := filepath.Join(tmpPath, ".com_ibm_tools_attach", subdir, filename)
+// Close detaches from the JVM and closes the connection
+func (s *OpenJ9Session) Close(ctx context.Context, opts *Options) error {
+	defer watchContext(ctx, s.conn)()
+	s.conn.SetDeadline(phaseDeadline(ctx, opts.Timeout))
+	detach(s.conn)
+	return s.conn.Close()
+}
+
+// acquireLockContext acquires a file lock, but gives up when the timeout
+// expires or ctx is done
+func acquireLockContext(ctx context.Context, tmpPath, subdir, filename string, timeout time.Duration) (*os.File, error) {
+	f, err := openLockFile(tmpPath, subdir, filename)
+	if err != nil {
+		return nil, err
+	}
 
-	// Ensure directory exists
-	dir := filepath.Dir(lockPath)
-	os.MkdirAll(dir, 0755)
+	deadline := phaseDeadline(ctx, timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			f.Close()
+			return nil, &TimeoutError{Phase: "attach_lock"}
+		}
+		if err := sleepContext(ctx, 20*time.Millisecond); err != nil {
+			f.Close()
+			return nil, phaseError(ctx, "attach_lock", err)
+		}
+	}
+}
 
-	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE, 0666)
+// openAttachDir opens the .com_ibm_tools_attach directory under tmpPath,
+// creating it if create is set
+func openAttachDir(tmpPath string, create bool) (*os.File, error) {
+	tmp, err := openDir(tmpPath)
 	if err != nil {
 		return nil, err
 	}
+	defer tmp.Close()
+	if create {
+		if err := checkDir(tmp); err != nil {
+			return nil, err
+		}
+	}
+	return openDirAt(tmp, ".com_ibm_tools_attach", create, 0755)
+}
 
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
-		f.Close()
+// openLockFile opens a lock file, creating it and its directory if needed
+// Lock files are shared with the JVMs and attachers of other users and
+// never written to, so they may belong to anyone as long as they are
+// plain files
+func openLockFile(tmpPath, subdir, filename string) (*os.File, error) {
+	dir, err := openAttachDir(tmpPath, true)
+	if err != nil {
 		return nil, err
 	}
+	defer dir.Close()
 
-	return f, nil
+	if subdir != "" {
+		sub, err := openDirAt(dir, subdir, true, 0755)
+		if err != nil {
+			return nil, err
+		}
+		defer sub.Close()
+		dir = sub
+	}
+
+	return openShared(dir, filename, 0666)
 }
 
 // releaseLock releases a file lock
@@ -121,23 +221,6 @@ func releaseLock(f *os.File) {
 	}
 }
 
-// createAttachSocket creates a TCP listen socket on a random port
-// Tries IPv6 first, falls back to IPv4
-func createAttachSocket() (net.Listener, int, error) {
-	// Try IPv6 first
-	listener, err := net.Listen("tcp6", "[::]:0")
-	if err != nil {
-		// Fall back to IPv4
-		listener, err = net.Listen("tcp4", "0.0.0.0:0")
-		if err != nil {
-			return nil, 0, err
-		}
-	}
-
-	addr := listener.Addr().(*net.TCPAddr)
-	return listener, addr.Port, nil
-}
-
 // randomKey generates a 64-bit random key for authentication
 func randomKey() uint64 {
 	var key uint64
@@ -154,24 +237,47 @@ func randomKey() uint64 {
 	return key
 }
 
-// writeReplyInfo writes the connection info for the JVM to read
-func writeReplyInfo(path string, port int, key uint64) error {
-	// Ensure directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+// writeReplyInfo writes the connection info for the JVM to read into the
+// directory the JVM created for itself, which must be safe from other users
+func writeReplyInfo(tmpPath string, nspid int, port int, key uint64) (*artifact, error) {
+	attachDir, err := openAttachDir(tmpPath, false)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := openDirAt(attachDir, strconv.Itoa(nspid), false, 0)
+	attachDir.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDir(dir); err != nil {
+		dir.Close()
+		return nil, err
+	}
+
+	f, err := createFile(dir, "replyInfo", 0600)
+	if err != nil {
+		dir.Close()
+		return nil, err
 	}
+	replyInfo := &artifact{dir: dir, name: "replyInfo"}
 
 	content := fmt.Sprintf("%016x\n%d\n", key, port)
-	return os.WriteFile(path, []byte(content), 0600)
+	_, err = f.WriteString(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		replyInfo.remove()
+		return nil, err
+	}
+	return replyInfo, nil
 }
 
 // lockNotificationFiles locks all attachNotificationSync files
 func lockNotificationFiles(tmpPath string) ([]*os.File, int) {
 	locks := make([]*os.File, 0, maxNotifFiles)
-	attachDir := filepath.Join(tmpPath, ".com_ibm_tools_attach")
 
-	dir, err := os.Open(attachDir)
+	dir, err := openAttachDir(tmpPath, false)
 	if err != nil {
 		return locks, 0
 	}
@@ -187,18 +293,24 @@ func lockNotificationFiles(tmpPath string) ([]*os.File, int) {
 			continue
 		}
 
-		// Check if it's a directory (PID directory)
-		entryPath := filepath.Join(attachDir, entry)
-		info, err := os.Stat(entryPath)
-		if err != nil || !info.IsDir() {
+		// The directory of each JVM belongs to its user, symlinks and
+		// anything but directories are skipped
+		sub, err := openDirAt(dir, entry, false, 0)
+		if err != nil {
 			continue
 		}
 
 		// Try to lock the notification file
-		lock, err := acquireLock(tmpPath, entry, "attachNotificationSync")
-		if err == nil {
-			locks = append(locks, lock)
+		lock, err := openShared(sub, "attachNotificationSync", 0666)
+		sub.Close()
+		if err != nil {
+			continue
 		}
+		if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+			lock.Close()
+			continue
+		}
+		locks = append(locks, lock)
 
 		if len(locks) >= maxNotifFiles {
 			break
@@ -219,20 +331,11 @@ func unlockNotificationFiles(locks []*os.File, count int) {
 
 // Note: notifySemaphore is implemented in openj9_posix.go (Linux/FreeBSD) and openj9_stub.go (other platforms)
 
-// ftok generates a System V IPC key from a path
-func ftok(path string, projID int) (int, error) {
+// ftok generates a System V IPC key from an open file
+func ftok(f *os.File, projID int) (int, error) {
 	var st syscall.Stat_t
-	if err := syscall.Stat(path, &st); err != nil {
-		// If file doesn't exist, create it
-		f, err := os.Create(path)
-		if err != nil {
-			return 0, err
-		}
-		f.Close()
-
-		if err := syscall.Stat(path, &st); err != nil {
-			return 0, err
-		}
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return 0, &os.PathError{Op: "stat", Path: f.Name(), Err: err}
 	}
 
 	// Generate key: (projID << 24) | (st_dev & 0xff) << 16 | (st_ino & 0xffff)
@@ -241,16 +344,29 @@ func ftok(path string, projID int) (int, error) {
 }
 
 // acceptClient waits for the JVM to connect and validates the authentication key
-func acceptClient(listener net.Listener, expectedKey uint64) (net.Conn, error) {
-	// Set 5-second timeout for accept
-	if tcpListener, ok := listener.(*net.TCPListener); ok {
-		tcpListener.SetDeadline(time.Now().Add(5 * time.Second))
-	}
-
-	conn, err := listener.Accept()
-	if err != nil {
-		return nil, fmt.Errorf("JVM did not respond: %w", err)
+// Connections from other hosts are closed and logged, and the wait goes on
+func acceptClient(ctx context.Context, listener *replyListener, expectedKey uint64, deadline time.Time, opts *Options) (net.Conn, error) {
+	// Bound the accept and the authentication message by the deadline
+	listener.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		listener.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	var conn net.Conn
+	for {
+		var err error
+		conn, err = listener.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("JVM did not respond: %w", phaseError(ctx, "accept", err))
+		}
+		if localPeer(conn) {
+			break
+		}
+		opts.logf("Warning: rejected attach reply connection from %v", conn.RemoteAddr())
+		conn.Close()
 	}
+	conn.SetDeadline(deadline)
 
 	// Read authentication message: "ATTACH_CONNECTED {hex_key} "
 	authBuf := make([]byte, 35)
@@ -259,14 +375,14 @@ func acceptClient(listener net.Listener, expectedKey uint64) (net.Conn, error) {
 		read, err := conn.Read(authBuf[n:])
 		if err != nil {
 			conn.Close()
-			return nil, fmt.Errorf("JVM connection was prematurely closed: %w", err)
+			return nil, fmt.Errorf("JVM connection was prematurely closed: %w", phaseError(ctx, "accept", err))
 		}
 		n += read
 	}
 
-	// Validate authentication
+	// Validate authentication, the last byte is the null terminator
 	expected := fmt.Sprintf("ATTACH_CONNECTED %016x ", expectedKey)
-	if string(authBuf) != expected {
+	if subtle.ConstantTimeCompare(authBuf[:len(expected)], []byte(expected)) != 1 {
 		conn.Close()
 		return nil, fmt.Errorf("unexpected JVM response")
 	}
@@ -277,6 +393,23 @@ func acceptClient(listener net.Listener, expectedKey uint64) (net.Conn, error) {
 	return conn, nil
 }
 
+// localPeer reports whether conn comes from this host, over the loopback
+// interface or from the address it was accepted on
+// This is not a check of the process: any local process may connect, and
+// only the key of the replyInfo file, which is readable by the target's
+// user alone, tells the JVM apart
+func localPeer(conn net.Conn) bool {
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if remote.IP.IsLoopback() {
+		return true
+	}
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	return ok && remote.IP.Equal(local.IP)
+}
+
 // writeCommandOpenJ9 sends a null-terminated command to the JVM
 func writeCommandOpenJ9(conn net.Conn, cmd string) error {
 	data := []byte(cmd)
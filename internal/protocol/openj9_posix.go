@@ -28,10 +28,20 @@ func notifySemaphore(tmpPath string, value int, count int) error {
 		return nil
 	}
 
-	notifierPath := tmpPath + "/.com_ibm_tools_attach/_notifier"
+	// The JVMs create _notifier, it may belong to another user
+	dir, err := openAttachDir(tmpPath, false)
+	if err != nil {
+		return err
+	}
+	notifier, err := openShared(dir, "_notifier", 0666)
+	dir.Close()
+	if err != nil {
+		return err
+	}
 
 	// Generate semaphore key using ftok
-	semKey, err := ftok(notifierPath, 0xa1)
+	semKey, err := ftok(notifier, 0xa1)
+	notifier.Close()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,50 @@
+//go:build linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkPeer verifies with SO_PEERCRED that the process listening on the
+// other end of conn is the target, running as the current user. The kernel
+// reports the PID in our PID namespace, or 0 if the peer is not visible in
+// it; the PID of a foreign target cannot be compared, only the user
+func checkPeer(conn net.Conn, path string, t *Target) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("failed to get peer credentials: %w", credErr)
+	}
+
+	if cred.Pid != 0 && !t.Foreign && int(cred.Pid) != t.PID {
+		return &SecurityError{Path: path, Reason: fmt.Sprintf("peer is process %d, not %d", cred.Pid, t.PID)}
+	}
+	if uid := os.Geteuid(); uid != 0 && int(cred.Uid) != uid {
+		return &SecurityError{Path: path, Reason: fmt.Sprintf("peer runs as uid %d, not %d", cred.Uid, uid)}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPeer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// The listener is the test process itself
+	tests := []struct {
+		name   string
+		target *Target
+		ok     bool
+	}{
+		{"target", &Target{PID: os.Getpid()}, true},
+		{"other process", &Target{PID: 4194000}, false},
+		{"foreign target", &Target{PID: 4194000, Foreign: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			err = checkPeer(conn, path, tt.target)
+			var secErr *SecurityError
+			if tt.ok && err != nil {
+				t.Errorf("checkPeer() = %v", err)
+			}
+			if !tt.ok && !errors.As(err, &secErr) {
+				t.Errorf("checkPeer() = %v, want a SecurityError", err)
+			}
+		})
+	}
+}
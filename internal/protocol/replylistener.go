@@ -0,0 +1,143 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// replyListener accepts the connection of an OpenJ9 JVM on one port of one
+// or more addresses. The JVM connects back to
+// InetAddress.getLoopbackAddress(), which is 127.0.0.1, or ::1 when
+// java.net.preferIPv6Addresses is set, and the replyInfo file only holds
+// the port, so by default both loopback addresses are bound to the same
+// port
+type replyListener struct {
+	listeners []*net.TCPListener
+	port      int
+
+	once    sync.Once
+	accepts chan acceptResult
+	done    chan struct{}
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// listenReply binds the reply listener to a random port of address, or of
+// both loopback addresses if address is empty. A host without IPv6 or
+// without IPv4 loopback gets the other one only
+func listenReply(address string) (*replyListener, error) {
+	if address != "" {
+		l, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+		if err != nil {
+			return nil, err
+		}
+		return newReplyListener(l.(*net.TCPListener)), nil
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 10; attempt++ {
+		v4, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			// No IPv4 loopback, the JVM can only use ::1
+			v6, err6 := net.Listen("tcp6", "[::1]:0")
+			if err6 != nil {
+				return nil, err
+			}
+			return newReplyListener(v6.(*net.TCPListener)), nil
+		}
+
+		port := v4.Addr().(*net.TCPAddr).Port
+		v6, err := net.Listen("tcp6", net.JoinHostPort("::1", strconv.Itoa(port)))
+		if err == nil {
+			return newReplyListener(v4.(*net.TCPListener), v6.(*net.TCPListener)), nil
+		}
+		v4.Close()
+		lastErr = err
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			// No IPv6 loopback, the JVM can only use 127.0.0.1
+			v4, err = net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			return newReplyListener(v4.(*net.TCPListener)), nil
+		}
+		// The port is taken on ::1, try another one
+	}
+	return nil, lastErr
+}
+
+func newReplyListener(listeners ...*net.TCPListener) *replyListener {
+	return &replyListener{
+		listeners: listeners,
+		port:      listeners[0].Addr().(*net.TCPAddr).Port,
+		accepts:   make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+}
+
+// Accept returns the next connection on any of the addresses. The first
+// accept error, such as the deadline, is returned and ends the accept
+func (l *replyListener) Accept() (net.Conn, error) {
+	l.once.Do(func() {
+		for _, listener := range l.listeners {
+			go l.serve(listener)
+		}
+	})
+
+	select {
+	case r := <-l.accepts:
+		return r.conn, r.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// serve hands the connections of one listener to Accept, until it fails
+// The error, e.g. the deadline, ends the wait of Accept
+func (l *replyListener) serve(listener *net.TCPListener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case l.accepts <- acceptResult{conn, err}:
+		case <-l.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SetDeadline sets the accept deadline of every address
+func (l *replyListener) SetDeadline(t time.Time) {
+	for _, listener := range l.listeners {
+		listener.SetDeadline(t)
+	}
+}
+
+// Close stops listening on every address
+func (l *replyListener) Close() error {
+	close(l.done)
+	var err error
+	for _, listener := range l.listeners {
+		if e := listener.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// connectBack connects to the reply port like an OpenJ9 JVM and sends the
+// authentication message. It runs in its own goroutine
+func connectBack(t *testing.T, host string, port int, key uint64) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "ATTACH_CONNECTED %016x \x00", key)
+}
+
+func TestReplyListenerBothLoopbacks(t *testing.T) {
+	listener, err := listenReply("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	hosts := []string{"127.0.0.1", "::1"}
+	if len(listener.listeners) == 1 {
+		t.Logf("only %v is available", listener.listeners[0].Addr())
+		hosts = []string{listener.listeners[0].Addr().(*net.TCPAddr).IP.String()}
+	}
+
+	// A JVM preferring IPv6 connects to ::1, on the same port
+	opts := &Options{}
+	for i, host := range hosts {
+		key := uint64(0x1234 + i)
+		go connectBack(t, host, listener.port, key)
+		conn, err := acceptClient(context.Background(), listener, key, time.Now().Add(5*time.Second), opts)
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if got := conn.RemoteAddr().(*net.TCPAddr).IP; !got.Equal(net.ParseIP(host)) {
+			t.Errorf("connection from %v, want %s", got, host)
+		}
+		conn.Close()
+	}
+}
+
+func TestReplyListenerDeadline(t *testing.T) {
+	listener, err := listenReply("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = acceptClient(context.Background(), listener, 1, time.Now().Add(50*time.Millisecond), &Options{})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != "accept" {
+		t.Errorf("got %v, want an accept timeout", err)
+	}
+
+	// Close stops the accept goroutines, whose listeners are closed
+	listener.Close()
+	for _, l := range listener.listeners {
+		if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			t.Errorf("%v still listening", l.Addr())
+		}
+	}
+}
+
+func TestReplyListenerAddress(t *testing.T) {
+	listener, err := listenReply("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if len(listener.listeners) != 1 || listener.port != listener.listeners[0].Addr().(*net.TCPAddr).Port {
+		t.Fatalf("listeners = %v, port %d", listener.listeners, listener.port)
+	}
+	go connectBack(t, "127.0.0.1", listener.port, 42)
+	conn, err := acceptClient(context.Background(), listener, 42, time.Now().Add(5*time.Second), &Options{Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
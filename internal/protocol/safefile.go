@@ -0,0 +1,205 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The attach files live in directories shared with other users, like /tmp,
+// often reached through /proc/<pid>/root. They are always opened relative
+// to a directory descriptor and without following symlinks, so a planted
+// link cannot redirect them to another file
+
+// openDir opens the directory at path. Symlinks are followed up to a
+// /proc/<pid>/root link, or /proc/<pid>/cwd which is the trigger directory
+// itself, since those are the JVM's own. What lies below the root link is
+// controlled by the JVM's container, so it is resolved within that root
+// and an absolute symlink, such as a planted /tmp -> /etc, cannot reach the
+// attacher's filesystem. The names opened below the directory with
+// openDirAt, createFile and openShared are not followed at all
+func openDir(path string) (*os.File, error) {
+	root, rel := splitProcRoot(path)
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	dir := os.NewFile(uintptr(fd), root)
+	if rel == "" {
+		return dir, nil
+	}
+	defer dir.Close()
+	return openInRoot(dir, rel)
+}
+
+// splitProcRoot splits path after its first /proc/<pid>/root component, if
+// any. rel is empty for other paths
+func splitProcRoot(path string) (root, rel string) {
+	elems := strings.Split(filepath.Clean(path), "/")
+	for i := 1; i+1 < len(elems); i++ {
+		if elems[i+1] != "root" {
+			continue
+		}
+		if _, err := strconv.Atoi(elems[i]); err == nil {
+			root = strings.Join(elems[:i+2], "/")
+			return root, strings.Join(elems[i+2:], "/")
+		}
+	}
+	return path, ""
+}
+
+// openBeneath opens the directory rel below root one name at a time, so
+// that none of its components may be a symlink
+func openBeneath(root *os.File, rel string) (*os.File, error) {
+	dir := root
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		next, err := openDirAt(dir, name, false, 0)
+		if dir != root {
+			dir.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		dir = next
+	}
+	if dir == root {
+		return openDirAt(root, ".", false, 0)
+	}
+	return dir, nil
+}
+
+// openDirAt opens the directory name in dir, creating it with mode first
+// if create is set. name must not be a symlink
+func openDirAt(dir *os.File, name string, create bool, mode uint32) (*os.File, error) {
+	path := filepath.Join(dir.Name(), name)
+	if create {
+		if err := unix.Mkdirat(int(dir.Fd()), name, mode); err != nil && !errors.Is(err, unix.EEXIST) {
+			return nil, &os.PathError{Op: "mkdir", Path: path, Err: err}
+		}
+	}
+
+	fd, err := unix.Openat(int(dir.Fd()), name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, openErrorAt(dir, name, err)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// checkDir makes sure other users cannot replace the files created in dir:
+// it must be sticky, or be owned by the current user or root and be
+// writable by nobody else
+func checkDir(dir *os.File) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(dir.Fd()), &st); err != nil {
+		return &os.PathError{Op: "stat", Path: dir.Name(), Err: err}
+	}
+
+	mode := uint32(st.Mode)
+	if mode&unix.S_ISVTX != 0 {
+		return nil
+	}
+	if int(st.Uid) != os.Geteuid() && st.Uid != 0 {
+		return &SecurityError{Path: dir.Name(), Reason: fmt.Sprintf("directory owned by uid %d is not sticky", st.Uid)}
+	}
+	if mode&0022 != 0 {
+		return &SecurityError{Path: dir.Name(), Reason: fmt.Sprintf("directory writable by group or others is not sticky (mode %#o)", mode&0777)}
+	}
+	return nil
+}
+
+// createFile creates name in dir for the current user alone. A leftover
+// regular file of the current user is replaced, anything else under that
+// name is refused
+func createFile(dir *os.File, name string, mode uint32) (*os.File, error) {
+	const flags = unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL | unix.O_NOFOLLOW | unix.O_CLOEXEC
+	fd, err := unix.Openat(int(dir.Fd()), name, flags, mode)
+	if errors.Is(err, unix.EEXIST) {
+		if err := removeStale(dir, name); err != nil {
+			return nil, err
+		}
+		fd, err = unix.Openat(int(dir.Fd()), name, flags, mode)
+	}
+	if err != nil {
+		return nil, openErrorAt(dir, name, err)
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name)), nil
+}
+
+// removeStale removes a regular file of the current user left behind by an
+// earlier attach
+func removeStale(dir *os.File, name string) error {
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(dir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "stat", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	if uint32(st.Mode)&unix.S_IFMT == unix.S_IFLNK {
+		return &SecurityError{Path: filepath.Join(dir.Name(), name), Reason: "is a symbolic link"}
+	}
+	if uint32(st.Mode)&unix.S_IFMT != unix.S_IFREG || int(st.Uid) != os.Geteuid() {
+		return &SecurityError{
+			Path:   filepath.Join(dir.Name(), name),
+			Reason: fmt.Sprintf("already exists with mode %#o and owner uid %d", uint32(st.Mode), st.Uid),
+		}
+	}
+	return unix.Unlinkat(int(dir.Fd()), name, 0)
+}
+
+// openShared opens name in dir for writing, creating it with mode if
+// needed. Lock and notification files are shared with other users, so they
+// may belong to anyone, but they must be plain files with a single link.
+// O_NONBLOCK keeps a planted FIFO from blocking the open
+func openShared(dir *os.File, name string, mode uint32) (*os.File, error) {
+	const flags = unix.O_WRONLY | unix.O_CREAT | unix.O_NOFOLLOW | unix.O_NONBLOCK | unix.O_CLOEXEC
+	path := filepath.Join(dir.Name(), name)
+	fd, err := unix.Openat(int(dir.Fd()), name, flags, mode)
+	if err != nil {
+		return nil, openErrorAt(dir, name, err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if uint32(st.Mode)&unix.S_IFMT != unix.S_IFREG || uint64(st.Nlink) != 1 {
+		unix.Close(fd)
+		return nil, &SecurityError{Path: path, Reason: fmt.Sprintf("not a regular file with a single link (mode %#o, %d links)", uint32(st.Mode), uint64(st.Nlink))}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// openErrorAt reports a failed open of name in dir, as a SecurityError if
+// it is a symlink
+func openErrorAt(dir *os.File, name string, err error) error {
+	var st unix.Stat_t
+	path := filepath.Join(dir.Name(), name)
+	if unix.Fstatat(int(dir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW) == nil && uint32(st.Mode)&unix.S_IFMT == unix.S_IFLNK {
+		return &SecurityError{Path: path, Reason: "is a symbolic link"}
+	}
+	return &os.PathError{Op: "open", Path: path, Err: err}
+}
+
+// artifact is a file created for the attach, removed once it is done
+type artifact struct {
+	dir  *os.File
+	name string
+}
+
+// remove deletes the file and releases its directory
+func (a *artifact) remove() {
+	unix.Unlinkat(int(a.dir.Fd()), a.name, 0)
+	a.dir.Close()
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// openInRoot opens the directory rel below root as if root were "/", so
+// that symlinks, absolute ones included, cannot leave it. Kernels without
+// openat2 (before 5.6, or filtered by seccomp) get openBeneath instead,
+// which refuses symlinks altogether
+func openInRoot(root *os.File, rel string) (*os.File, error) {
+	how := &unix.OpenHow{
+		Flags:   unix.O_RDONLY | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	fd, err := unix.Openat2(int(root.Fd()), rel, how)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) {
+		return openBeneath(root, rel)
+	}
+	path := filepath.Join(root.Name(), rel)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package protocol
+
+import "os"
+
+// openInRoot opens the directory rel below root, refusing symlinks
+func openInRoot(root *os.File, rel string) (*os.File, error) {
+	return openBeneath(root, rel)
+}
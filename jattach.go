@@ -7,8 +7,12 @@ package jattach
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -27,6 +31,7 @@ func NewClient() *Client {
 		options: &Options{
 			PrintOutput: false,
 			Timeout:     6 * time.Second,
+			ReadTimeout: 6 * time.Second,
 		},
 	}
 }
@@ -36,6 +41,9 @@ func NewClientWithOptions(opts *Options) *Client {
 	if opts.Timeout == 0 {
 		opts.Timeout = 6 * time.Second
 	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = opts.Timeout
+	}
 	return &Client{options: opts}
 }
 
@@ -47,77 +55,333 @@ func (c *Client) Attach(pid int, cmd string, args ...string) (*Response, error)
 
 // AttachWithContext allows cancellation via context
 func (c *Client) AttachWithContext(ctx context.Context, pid int, cmd string, args ...string) (*Response, error) {
-	// Ignore SIGPIPE to prevent crashes on broken socket writes
-	signal.Ignore(syscall.SIGPIPE)
+	if c.options.Helper {
+		msg, body, err := c.attachHelper(ctx, pid, cmd, args, false)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
 
-	// Get process information (UID, GID, namespace PID)
-	info, err := process.GetProcessInfo(pid)
+		output, err := io.ReadAll(body)
+		if err != nil {
+			return nil, wrapError("helper", pid, err)
+		}
+		return &Response{
+			Code:    msg.Code,
+			Output:  string(output),
+			JVMType: msg.JVMType,
+			PID:     pid,
+			NsPID:   msg.NsPID,
+			Command: cmd,
+			Args:    args,
+			Timings: msg.Timings,
+		}, nil
+	}
+
+	var resp *Response
+	err := c.withTarget(pid, func(t *target) error {
+		opts := c.protocolOptions(c.options.PrintOutput)
+		opts.Timings = &t.timings
+
+		// Dispatch to appropriate protocol handler
+		var protoResp *protocol.Response
+		var err error
+		if t.jvmType == JVMTypeOpenJ9 {
+			protoResp, err = protocol.AttachOpenJ9(ctx, t.protocolTarget(), cmd, args, opts)
+		} else {
+			protoResp, err = protocol.AttachHotSpot(ctx, t.protocolTarget(), cmd, args, opts)
+		}
+
+		if err != nil {
+			return attachError(pid, err)
+		}
+
+		// Convert protocol.Response to jattach.Response
+		resp = &Response{
+			Code:    protoResp.Code,
+			Output:  protoResp.Output,
+			JVMType: t.jvmType,
+			PID:     pid,
+			NsPID:   t.info.NsPID,
+			Command: cmd,
+			Args:    args,
+		}
+		resp.Timings = convertTimings(t.timings)
+		return nil
+	})
 	if err != nil {
-		return nil, wrapError("get_process_info", pid, ErrProcessNotFound)
+		return nil, err
 	}
 
-	// Enter container namespaces if on Linux (net, ipc, mnt)
-	mntChanged := 0
-	for _, nsType := range []string{"net", "ipc", "mnt"} {
-		result, err := process.EnterNamespace(pid, nsType)
+	return resp, nil
+}
+
+// AttachStream sends a command to the JVM process and returns as soon as
+// the return code is known. The response body is read from the returned
+// stream, which the caller must close
+func (c *Client) AttachStream(ctx context.Context, pid int, cmd string, args ...string) (*ResponseStream, error) {
+	if c.options.Helper {
+		msg, body, err := c.attachHelper(ctx, pid, cmd, args, true)
 		if err != nil {
-			// Not fatal, continue
-			if c.options.Logger != nil {
-				c.options.Logger.Printf("Warning: failed to enter %s namespace: %v", nsType, err)
+			return nil, err
+		}
+		return &ResponseStream{
+			Code:    msg.Code,
+			JVMType: msg.JVMType,
+			body:    body,
+		}, nil
+	}
+
+	var stream *ResponseStream
+	err := c.withTarget(pid, func(t *target) error {
+		if t.jvmType == JVMTypeOpenJ9 {
+			// OpenJ9 replies with a single null-terminated message, so there
+			// is nothing to gain from streaming it
+			protoResp, err := protocol.AttachOpenJ9(ctx, t.protocolTarget(), cmd, args, c.protocolOptions(false))
+			if err != nil {
+				return attachError(pid, err)
+			}
+			stream = &ResponseStream{
+				Code:    protoResp.Code,
+				JVMType: t.jvmType,
+				body:    io.NopCloser(strings.NewReader(protoResp.Output)),
 			}
+			return nil
+		}
+
+		protoStream, err := protocol.OpenHotSpot(ctx, t.protocolTarget(), cmd, args, c.protocolOptions(false))
+		if err != nil {
+			return attachError(pid, err)
 		}
-		if nsType == "mnt" && result > 0 {
-			mntChanged = result
+		stream = &ResponseStream{
+			Code:    protoStream.Code,
+			JVMType: t.jvmType,
+			body:    protoStream.Body,
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Switch to target process credentials (required by HotSpot security model)
-	if err := syscall.Setgid(int(info.GID)); err != nil {
-		return nil, wrapError("setgid", pid, ErrPermissionDenied)
+	return stream, nil
+}
+
+// command runs cmd for a typed result. The output is never printed, and a
+// non-zero return code is reported as ErrCommandFailed
+func (c *Client) command(ctx context.Context, pid int, cmd string, args ...string) (string, JVMType, error) {
+	stream, err := c.AttachStream(ctx, pid, cmd, args...)
+	if err != nil {
+		return "", JVMTypeUnknown, err
+	}
+	defer stream.Close()
+
+	output, err := io.ReadAll(stream)
+	if err != nil {
+		return "", stream.JVMType, attachError(pid, err)
 	}
-	if err := syscall.Setuid(int(info.UID)); err != nil {
-		return nil, wrapError("setuid", pid, ErrPermissionDenied)
+	if stream.Code != 0 {
+		return "", stream.JVMType, commandError(cmd, pid, stream.Code, string(output))
 	}
+	return string(output), stream.JVMType, nil
+}
+
+// target describes a JVM process ready to be attached to
+type target struct {
+	pid        int
+	info       *process.Info
+	tmpPath    string
+	jvmType    JVMType
+	mntChanged int
+	procRoot   string
+
+	// pidfd pins the process from the lookup on, -1 if not available
+	pidfd int
+
+	// foreign is set when procRoot lists another PID namespace, see
+	// protocol.Target.Foreign
+	foreign bool
+
+	// timings collects the attach phases, starting with "resolve"
+	timings []protocol.Timing
+}
 
-	// Determine temporary path
-	tmpPath := c.options.TmpPath
-	if tmpPath == "" {
-		tmpPath = os.Getenv("JATTACH_PATH")
+// protocolTarget converts the target for the protocol handlers
+func (t *target) protocolTarget() *protocol.Target {
+	return &protocol.Target{
+		PID:        t.pid,
+		NsPID:      t.info.NsPID,
+		TmpPath:    t.tmpPath,
+		MntChanged: t.mntChanged,
+		ProcRoot:   process.Root(t.procRoot),
+		PidFD:      t.pidfd,
+		StartTime:  t.info.StartTime,
+		Foreign:    t.foreign,
 	}
-	if tmpPath == "" {
-		var err error
-		tmpPath, err = process.GetTmpPath(pid)
-		if err != nil {
-			tmpPath = "/tmp"
-		}
+}
+
+// protocolOptions converts the client options for the protocol handlers
+func (c *Client) protocolOptions(printOutput bool) *protocol.Options {
+	opts := &protocol.Options{
+		PrintOutput:  printOutput,
+		Timeout:      c.options.Timeout,
+		ReadTimeout:  c.options.ReadTimeout,
+		ReplyAddress: c.options.ReplyAddress,
+		SignalHook:   c.options.SignalHook,
 	}
+	if c.options.Logger != nil {
+		opts.Logf = c.options.Logger.Printf
+	}
+	return opts
+}
 
-	// Detect JVM type (OpenJ9 vs HotSpot)
-	jvmType := JVMTypeHotSpot
-	if protocol.IsOpenJ9Process(tmpPath, info.NsPID) {
-		jvmType = JVMTypeOpenJ9
+// convertTimings converts the phase durations of the protocol handlers
+func convertTimings(timings []protocol.Timing) []Timing {
+	var converted []Timing
+	for _, timing := range timings {
+		converted = append(converted, Timing{Phase: timing.Phase, Duration: timing.Duration})
 	}
+	return converted
+}
 
-	// Dispatch to appropriate protocol handler
-	var protoResp *protocol.Response
-	if jvmType == JVMTypeOpenJ9 {
-		protoResp, err = protocol.AttachOpenJ9(ctx, pid, info.NsPID, tmpPath, cmd, args, c.options.PrintOutput)
-	} else {
-		protoResp, err = protocol.AttachHotSpot(ctx, pid, info.NsPID, tmpPath, mntChanged, cmd, args, c.options.PrintOutput)
+// tmpPath returns the temporary directory of the target, where its attach
+// files are
+func (c *Client) tmpPath(pid int) string {
+	if c.options.TmpPath != "" {
+		return c.options.TmpPath
+	}
+	if path := os.Getenv("JATTACH_PATH"); path != "" {
+		return path
+	}
+	path, err := process.GetTmpPath(c.options.ProcRoot, pid)
+	if err != nil {
+		return "/tmp"
+	}
+	return path
+}
+
+// detectJVMType tells OpenJ9 from HotSpot by the attach files of OpenJ9
+func detectJVMType(tmpPath string, nspid int) JVMType {
+	if protocol.IsOpenJ9Process(tmpPath, nspid) {
+		return JVMTypeOpenJ9
 	}
+	return JVMTypeHotSpot
+}
 
+// jvmType detects the JVM type of the target before attaching, from the
+// files its temporary directory holds. The directory is reached through
+// the procfs, so neither its namespaces nor its credentials are needed
+func (c *Client) jvmType(pid int) JVMType {
+	info, err := process.GetProcessInfo(c.options.ProcRoot, pid)
 	if err != nil {
-		return nil, wrapError("attach", pid, err)
+		return JVMTypeUnknown
 	}
+	return detectJVMType(c.tmpPath(pid), info.NsPID)
+}
 
-	// Convert protocol.Response to jattach.Response
-	resp := &Response{
-		Code:    protoResp.Code,
-		Output:  protoResp.Output,
-		JVMType: jvmType,
+// switchCredentials switches the process to the given user and group
+// The supplementary groups are dropped first, so the attach does not keep
+// the attacher's access to group-owned files and sockets. They are left
+// alone when the credentials do not change, since dropping them needs
+// privileges
+func switchCredentials(pid int, uid, gid uint32) error {
+	if int(uid) != os.Geteuid() || int(gid) != os.Getegid() {
+		if err := syscall.Setgroups(nil); err != nil {
+			return wrapError("setgroups", pid, ErrPermissionDenied)
+		}
+	}
+	if err := syscall.Setgid(int(gid)); err != nil {
+		return wrapError("setgid", pid, ErrPermissionDenied)
+	}
+	if err := syscall.Setuid(int(uid)); err != nil {
+		return wrapError("setuid", pid, ErrPermissionDenied)
 	}
+	return nil
+}
 
-	return resp, nil
+// withTarget resolves the target process and runs fn inside its
+// namespaces with its credentials, once the JVM type is known
+func (c *Client) withTarget(pid int, fn func(t *target) error) error {
+	// Ignore SIGPIPE to prevent crashes on broken socket writes
+	signal.Ignore(syscall.SIGPIPE)
+
+	start := time.Now()
+
+	// Get process information (UID, GID, namespace PID)
+	procRoot := c.options.ProcRoot
+	info, err := process.GetProcessInfo(procRoot, pid)
+	if err != nil {
+		return wrapError("get_process_info", pid, ErrProcessNotFound)
+	}
+
+	t := &target{pid: pid, info: info, procRoot: procRoot, pidfd: -1}
+
+	// Pin the process, then make sure the PID was not reused between the
+	// lookup and pidfd_open. A PID of another namespace cannot be pinned,
+	// the start time read through procRoot is checked before each use
+	// instead
+	if !process.LocalProcfs(procRoot) {
+		t.foreign = true
+	} else if pidfd, err := process.OpenPidfd(pid); err == nil {
+		defer process.ClosePidfd(pidfd)
+		t.pidfd = pidfd
+		if startTime, err := process.StartTime(procRoot, pid); err != nil || startTime != info.StartTime {
+			return wrapError("get_process_info", pid, ErrProcessNotFound)
+		}
+	} else if errors.Is(err, syscall.ESRCH) {
+		return wrapError("get_process_info", pid, ErrProcessNotFound)
+	}
+	if !process.SameNamespace(procRoot, pid, "mnt") {
+		t.mntChanged = 1
+	}
+
+	// entered tells whether run is inside the target's namespaces
+	run := func(entered bool) error {
+		// Switch to target process credentials (required by HotSpot security model)
+		if err := switchCredentials(pid, info.UID, info.GID); err != nil {
+			return err
+		}
+
+		t.tmpPath = c.tmpPath(pid)
+		t.jvmType = detectJVMType(t.tmpPath, info.NsPID)
+
+		// The OpenJ9 listener connects back over TCP after a SysV semaphore
+		// notification, neither of which crosses namespaces
+		if t.jvmType == JVMTypeOpenJ9 && !entered &&
+			(!process.SameNamespace(procRoot, pid, "net") || !process.SameNamespace(procRoot, pid, "ipc")) {
+			return wrapError("attach_openj9", pid,
+				fmt.Errorf("%w: OpenJ9 is only reachable from its net and ipc namespaces", ErrNamespaceRequired))
+		}
+
+		t.timings = []protocol.Timing{{Phase: "resolve", Duration: time.Since(start)}}
+		return fn(t)
+	}
+
+	if c.options.NamespaceMode == NamespaceNone {
+		// Reach the target through /proc/<pid>/root and /proc/<pid>/cwd
+		t.mntChanged = 0
+		return run(false)
+	}
+
+	// Enter container namespaces if on Linux (net, ipc, mnt)
+	err = process.WithNamespaces(procRoot, pid, []string{"net", "ipc", "mnt"}, func() error {
+		return run(true)
+	})
+	var nsErr *process.NamespaceError
+	if errors.As(err, &nsErr) {
+		if c.options.NamespaceMode == NamespaceEnter {
+			return wrapError("enter_namespace", pid, fmt.Errorf("%w: %w", ErrNamespaceRequired, nsErr))
+		}
+
+		// Not fatal, continue from the current namespaces
+		if c.options.Logger != nil {
+			c.options.Logger.Printf("Warning: %v", nsErr)
+		}
+		t.mntChanged = 0
+		err = run(false)
+	}
+
+	return err
 }
 
 // LoadAgent loads a native agent library into the target JVM
@@ -173,7 +437,13 @@ func (c *Client) GetAgentProperties(pid int) (*Response, error) {
 }
 
 // SetFlag modifies a manageable VM flag
+// The flag is first looked up with Flags; an unknown or non-manageable flag
+// fails with a *FlagError (ErrUnknownFlag or ErrFlagNotManageable) without
+// being sent
 func (c *Client) SetFlag(pid int, flag string, value string) (*Response, error) {
+	if err := c.checkFlag(context.Background(), pid, flag); err != nil {
+		return nil, err
+	}
 	return c.Attach(pid, CmdSetFlag, flag, value)
 }
 
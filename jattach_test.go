@@ -0,0 +1,241 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+// newHotSpot starts a fake HotSpot JVM and a client attaching to it
+func newHotSpot(t *testing.T, script jattachtest.Script) (*jattach.Client, *jattachtest.Server) {
+	t.Helper()
+	jvm, err := jattachtest.NewHotSpot(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jvm.Close() })
+	return newClient(jvm, nil), jvm
+}
+
+// newOpenJ9 starts a fake OpenJ9 JVM and a client attaching to it
+func newOpenJ9(t *testing.T, script jattachtest.Script) (*jattach.Client, *jattachtest.Server) {
+	t.Helper()
+	jvm, err := jattachtest.NewOpenJ9(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jvm.Close() })
+	return newClient(jvm, nil), jvm
+}
+
+// newClient returns a client of jvm with the given options, nil for the
+// defaults. TmpPath and SignalHook are set for jvm
+func newClient(jvm *jattachtest.Server, opts *jattach.Options) *jattach.Client {
+	if opts == nil {
+		opts = &jattach.Options{}
+	}
+	opts.TmpPath = jvm.TmpPath()
+	opts.SignalHook = jvm.Signal
+	return jattach.NewClientWithOptions(opts)
+}
+
+// commandKeys returns the Script keys of the commands received by jvm
+func commandKeys(jvm *jattachtest.Server) string {
+	var keys []string
+	for _, cmd := range jvm.Commands() {
+		keys = append(keys, cmd.Key())
+	}
+	return strings.Join(keys, ", ")
+}
+
+func TestAttach(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   func(t *testing.T, script jattachtest.Script) (*jattach.Client, *jattachtest.Server)
+		script  jattachtest.Script
+		jvmType jattach.JVMType
+		version string
+	}{
+		{"HotSpot 8", newHotSpot, jattachtest.HotSpot8(), jattach.JVMTypeHotSpot, "25.412-b08"},
+		{"HotSpot 17", newHotSpot, jattachtest.HotSpot17(), jattach.JVMTypeHotSpot, "17.0.11+9"},
+		{"HotSpot 21", newHotSpot, jattachtest.HotSpot21(), jattach.JVMTypeHotSpot, "21.0.3+9-LTS"},
+		{"OpenJ9", newOpenJ9, jattachtest.OpenJ9(), jattach.JVMTypeOpenJ9, "OpenJ9   - b04a1f1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, jvm := tt.start(t, tt.script)
+
+			// The second attach finds the listener already running
+			for i := 0; i < 2; i++ {
+				resp, err := client.Attach(jvm.PID(), "jcmd", "VM.version")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.Code != 0 || resp.JVMType != tt.jvmType || !strings.Contains(resp.Output, tt.version) {
+					t.Errorf("response = %+v", resp)
+				}
+				if resp.PID != jvm.PID() || len(resp.Timings) == 0 {
+					t.Errorf("response = %+v", resp)
+				}
+			}
+
+			cmds := jvm.Commands()
+			if len(cmds) != 2 || cmds[0].Name != "jcmd" || cmds[0].Args[0] != "VM.version" {
+				t.Errorf("commands = %+v", cmds)
+			}
+		})
+	}
+}
+
+func TestAttachUnknownCommand(t *testing.T) {
+	client, jvm := newHotSpot(t, jattachtest.HotSpot17())
+
+	resp, err := client.Attach(jvm.PID(), "jcmd", "Compiler.codecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 1 || !strings.Contains(resp.Output, "Unknown diagnostic command") {
+		t.Errorf("response = %+v", resp)
+	}
+	if got := commandKeys(jvm); got != "jcmd Compiler.codecache" {
+		t.Errorf("commands = %s", got)
+	}
+}
+
+func TestAttachStream(t *testing.T) {
+	client, jvm := newHotSpot(t, jattachtest.HotSpot17())
+
+	stream, err := client.AttachStream(context.Background(), jvm.PID(), "jcmd", "VM.uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	body, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Code != 0 || string(body) != "35.112 s\n" {
+		t.Errorf("stream = code %d, body %q", stream.Code, body)
+	}
+}
+
+func TestAttachPrintOutput(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+	client := newClient(jvm, &jattach.Options{PrintOutput: true})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	resp, err := client.Attach(jvm.PID(), "jcmd", "VM.uptime")
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The response is printed as read, and still returned whole
+	if !strings.HasSuffix(string(printed), "JVM response code = 0\n35.112 s\n\n") {
+		t.Errorf("printed %q", printed)
+	}
+	if resp.Output != "0\n35.112 s\n" {
+		t.Errorf("output = %q", resp.Output)
+	}
+}
+
+func TestAttachReadTimeout(t *testing.T) {
+	script := jattachtest.HotSpot17()
+	script["jcmd GC.heap_dump"] = jattachtest.Reply{Delay: time.Minute}
+	jvm, err := jattachtest.NewHotSpot(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	client := newClient(jvm, &jattach.Options{ReadTimeout: 100 * time.Millisecond})
+	_, err = client.Attach(jvm.PID(), "jcmd", "GC.heap_dump", "/tmp/heap.hprof")
+	if !errors.Is(err, jattach.ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestAttachReadTimeoutDefault(t *testing.T) {
+	script := jattachtest.HotSpot17()
+	script["jcmd GC.heap_dump"] = jattachtest.Reply{Delay: time.Minute}
+	script["jcmd GC.heap_info"] = jattachtest.Reply{Delay: 300 * time.Millisecond}
+	jvm, err := jattachtest.NewHotSpot(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	// The response read is bounded by Timeout unless told otherwise
+	client := newClient(jvm, &jattach.Options{Timeout: 100 * time.Millisecond})
+	_, err = client.Attach(jvm.PID(), "jcmd", "GC.heap_dump", "/tmp/heap.hprof")
+	var attachErr *jattach.AttachError
+	if !errors.Is(err, jattach.ErrTimeout) || !errors.As(err, &attachErr) || attachErr.Op != "read_response" {
+		t.Fatalf("got %v, want a read timeout", err)
+	}
+
+	client = newClient(jvm, &jattach.Options{Timeout: 100 * time.Millisecond, ReadTimeout: -1})
+	if _, err := client.Attach(jvm.PID(), "jcmd", "GC.heap_info"); err != nil {
+		t.Errorf("unbounded read: %v", err)
+	}
+}
+
+func TestSignalHookWithHelper(t *testing.T) {
+	_, jvm := newHotSpot(t, jattachtest.HotSpot17())
+	client := newClient(jvm, &jattach.Options{Helper: true})
+
+	_, err := client.Attach(jvm.PID(), "jcmd", "VM.version")
+	var attachErr *jattach.AttachError
+	if !errors.As(err, &attachErr) || attachErr.Op != "start_helper" {
+		t.Fatalf("got %v, want a start_helper error", err)
+	}
+	if len(jvm.Commands()) != 0 {
+		t.Errorf("fake JVM received %v", jvm.Commands())
+	}
+}
+
+func TestHelper(t *testing.T) {
+	// The helper is a copy of the test binary, attaching to the fake JVM
+	// of its parent. OpenJ9 needs no signal
+	jvm, err := jattachtest.NewOpenJ9(jattachtest.OpenJ9())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	client := jattach.NewClientWithOptions(&jattach.Options{TmpPath: jvm.TmpPath(), Helper: true})
+	resp, err := client.Attach(jvm.PID(), "jcmd", "VM.version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.JVMType != jattach.JVMTypeOpenJ9 || !strings.Contains(resp.Output, "OpenJ9") {
+		t.Errorf("response = %+v", resp)
+	}
+	if got := commandKeys(jvm); got != "jcmd VM.version" {
+		t.Errorf("commands = %s", got)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NewHotSpot starts a fake HotSpot JVM answering with script
+// Like a real JVM, it starts its attach listener on .java_pid<pid> when it
+// receives SIGQUIT and finds .attach_pid<pid> in its working directory or
+// temporary directory. The signal is received through Server.Signal
+func NewHotSpot(script Script) (*Server, error) {
+	s, err := newServer(script)
+	if err != nil {
+		return nil, err
+	}
+
+	// HotSpot publishes its performance counters, which tools use to find it
+	dir := filepath.Join(s.tmpPath, "hsperfdata_"+userName())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(s.pid)), perfData(), 0600); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.runHotSpot()
+	return s, nil
+}
+
+// runHotSpot waits for the attach signal and serves connections once the
+// listener is started
+func (s *Server) runHotSpot() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.signal:
+		}
+
+		if !s.attachRequested() {
+			continue
+		}
+
+		socketPath := filepath.Join(s.tmpPath, fmt.Sprintf(".java_pid%d", s.pid))
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			continue
+		}
+		// Like HotSpot, which clients check for
+		os.Chmod(socketPath, 0600)
+		if s.track(listener) {
+			s.serveHotSpot(listener)
+		}
+		return
+	}
+}
+
+// attachRequested looks for the trigger file where HotSpot does
+func (s *Server) attachRequested() bool {
+	name := fmt.Sprintf(".attach_pid%d", s.pid)
+	cwd, _ := os.Getwd()
+	for _, dir := range []string{cwd, s.tmpPath} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveHotSpot(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.trackConn(conn) {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handleHotSpot(conn)
+		}()
+	}
+}
+
+// handleHotSpot reads one request: protocol version, command and three
+// arguments, each NUL terminated
+func (s *Server) handleHotSpot(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	var fields []string
+	for i := 0; i < 5; i++ {
+		field, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		fields = append(fields, strings.TrimSuffix(field, "\x00"))
+	}
+	if fields[0] != "1" {
+		fmt.Fprintf(conn, "%d\n", 101) // ATTACH_ERROR_BADVERSION
+		return
+	}
+
+	cmd := Command{Name: fields[1], Args: trimArgs(fields[2:]), Wire: strings.Join(fields, "\x00") + "\x00"}
+	unknown := Reply{Code: -1, Output: fmt.Sprintf("Operation %s not recognized!", cmd.Name)}
+	if cmd.Name == "jcmd" {
+		unknown = Reply{Code: 1, Output: "java.lang.IllegalArgumentException: Unknown diagnostic command\n"}
+	}
+	reply := s.reply(cmd, unknown)
+
+	switch {
+	case reply.Raw:
+		fmt.Fprint(conn, reply.Output)
+	case cmd.Name == "load":
+		// JDK 9+: the attach succeeded, the agent result follows
+		fmt.Fprintf(conn, "0\nreturn code: %d\n%s", reply.Code, reply.Output)
+	default:
+		fmt.Fprintf(conn, "%d\n%s", reply.Code, reply.Output)
+	}
+}
+
+// trimArgs drops the empty arguments that pad a HotSpot request
+func trimArgs(args []string) []string {
+	for len(args) > 0 && args[len(args)-1] == "" {
+		args = args[:len(args)-1]
+	}
+	return args
+}
+
+// userName returns the user of the hsperfdata_<user> directory
+func userName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(os.Geteuid())
+}
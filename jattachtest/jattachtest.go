@@ -0,0 +1,239 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package jattachtest simulates the attach listeners of HotSpot and OpenJ9
+// JVMs, so that code built on jattach can be tested without Java
+//
+// A fake JVM runs inside the test process and uses its PID. It publishes
+// the attach files of a real JVM in its own temporary directory, which the
+// client under test must use:
+//
+//	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer jvm.Close()
+//
+//	client := jattach.NewClientWithOptions(&jattach.Options{
+//		TmpPath:    jvm.TmpPath(),
+//		SignalHook: jvm.Signal,
+//	})
+//	resp, err := client.Attach(jvm.PID(), "jcmd", "VM.version")
+//
+// The SIGQUIT that starts the HotSpot attach listener must be delivered to
+// the fake JVM through Server.Signal, or it would kill the test process.
+// Since the hook cannot cross processes, Options.Helper is not supported
+package jattachtest
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Command is a command received by a fake JVM
+type Command struct {
+	// Name is the HotSpot attach command. OpenJ9 commands are mapped back:
+	// ATTACH_GETSYSTEMPROPERTIES is "properties", ATTACH_GETAGENTPROPERTIES
+	// "agentProperties", ATTACH_DIAGNOSTICS "jcmd" and ATTACH_LOADAGENT and
+	// ATTACH_LOADAGENTPATH "load"
+	Name string
+
+	// Args are the arguments of the command, without trailing empty ones
+	Args []string
+
+	// Wire is the command as received, with NUL separators on HotSpot
+	Wire string
+}
+
+// Key returns the Script key of the command: the command name, or for jcmd
+// "jcmd" and the diagnostic command, e.g. "jcmd VM.version"
+func (c Command) Key() string {
+	if c.Name != "jcmd" || len(c.Args) == 0 {
+		return c.Name
+	}
+	fields := strings.Fields(c.Args[0])
+	if len(fields) == 0 {
+		return c.Name
+	}
+	return "jcmd " + fields[0]
+}
+
+// Reply is the answer of a fake JVM to a command
+type Reply struct {
+	// Code is the return code. For load it is the Agent_OnAttach result
+	Code int
+
+	// Output is the response text, encoded as the JVM would: after the
+	// code line on HotSpot, as a diagnostics property on OpenJ9
+	Output string
+
+	// Raw sends Output as is, without the code line or encoding
+	Raw bool
+
+	// Delay is waited before replying, e.g. to exercise timeouts
+	Delay time.Duration
+}
+
+// Script maps Command.Key values to replies. Commands without an entry get
+// the error reply of the JVM for unknown commands
+// HotSpot8, HotSpot17, HotSpot21 and OpenJ9 return the replies of those
+// JVMs to the common commands, to be changed or extended as needed
+type Script map[string]Reply
+
+// Server is a fake JVM
+type Server struct {
+	pid     int
+	tmpPath string
+	script  Script
+
+	mu          sync.Mutex
+	commands    []Command
+	connections int
+	closed      bool
+
+	// open holds the listeners and connections to close on Close
+	open map[io.Closer]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// signal wakes a HotSpot server up on SIGQUIT
+	signal chan struct{}
+}
+
+// PID returns the PID of the fake JVM, which is that of the test process
+func (s *Server) PID() int {
+	return s.pid
+}
+
+// TmpPath returns the temporary directory of the fake JVM, to be set as
+// Options.TmpPath of the client under test
+func (s *Server) TmpPath() string {
+	return s.tmpPath
+}
+
+// Commands returns the commands received so far, in order
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command(nil), s.commands...)
+}
+
+// Connections returns the number of attach connections served so far: one
+// per command on HotSpot, one per attach or session on OpenJ9
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// Close stops the fake JVM and removes its temporary directory
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	open := s.open
+	s.open = nil
+	s.mu.Unlock()
+
+	close(s.done)
+	for c := range open {
+		c.Close()
+	}
+	s.wg.Wait()
+	return os.RemoveAll(s.tmpPath)
+}
+
+// track registers c to be closed by Close. It returns false, having
+// closed c, if the server is already closed
+func (s *Server) track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c.Close()
+		return false
+	}
+	s.open[c] = true
+	return true
+}
+
+// trackConn is track for an attach connection, which is counted
+func (s *Server) trackConn(conn io.Closer) bool {
+	if !s.track(conn) {
+		return false
+	}
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+	return true
+}
+
+// untrack closes c and forgets it
+func (s *Server) untrack(c io.Closer) {
+	s.mu.Lock()
+	delete(s.open, c)
+	s.mu.Unlock()
+	c.Close()
+}
+
+// reply records the command and looks up its reply
+func (s *Server) reply(cmd Command, unknown Reply) Reply {
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	s.mu.Unlock()
+
+	r, ok := s.script[cmd.Key()]
+	if !ok {
+		return unknown
+	}
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-s.done:
+		}
+	}
+	return r
+}
+
+// newServer creates the temporary directory of a fake JVM
+func newServer(script Script) (*Server, error) {
+	tmpPath, err := os.MkdirTemp("", "jattachtest")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		pid:     os.Getpid(),
+		tmpPath: tmpPath,
+		script:  script,
+		open:    make(map[io.Closer]bool),
+		done:    make(chan struct{}),
+		signal:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Signal is the Options.SignalHook of the clients under test. The SIGQUIT
+// sent to the fake JVM wakes it up, which then checks for its trigger file
+// like a real JVM. It is never sent to the test process itself, which it
+// would kill. Signals to other processes are sent with kill(2)
+func (s *Server) Signal(pid int, sig syscall.Signal) error {
+	if pid != s.pid {
+		return syscall.Kill(pid, sig)
+	}
+	if sig == syscall.SIGQUIT {
+		select {
+		case s.signal <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
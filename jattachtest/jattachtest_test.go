@@ -0,0 +1,196 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/histogram"
+	"github.com/xxs-2/jattach-go/hsperf"
+	"github.com/xxs-2/jattach-go/internal/javaprops"
+	"github.com/xxs-2/jattach-go/jattachtest"
+	"github.com/xxs-2/jattach-go/threaddump"
+)
+
+func TestCommandKey(t *testing.T) {
+	tests := []struct {
+		cmd  jattachtest.Command
+		want string
+	}{
+		{jattachtest.Command{Name: "properties"}, "properties"},
+		{jattachtest.Command{Name: "jcmd", Args: []string{"VM.flags -all"}}, "jcmd VM.flags"},
+		{jattachtest.Command{Name: "jcmd", Args: []string{""}}, "jcmd"},
+		{jattachtest.Command{Name: "load", Args: []string{"instrument", "false", "agent.jar"}}, "load"},
+	}
+	for _, tt := range tests {
+		if got := tt.cmd.Key(); got != tt.want {
+			t.Errorf("%+v.Key() = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestHotSpotRecordsCommands(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	// Like HotSpot, the fake JVM publishes its performance counters
+	pattern := filepath.Join(jvm.TmpPath(), "hsperfdata_*", strconv.Itoa(jvm.PID()))
+	if matches, _ := filepath.Glob(pattern); len(matches) != 1 {
+		t.Errorf("hsperfdata files = %v", matches)
+	}
+
+	client := jattach.NewClientWithOptions(&jattach.Options{TmpPath: jvm.TmpPath(), SignalHook: jvm.Signal})
+	if _, err := client.LoadJavaAgent(jvm.PID(), "/opt/agent.jar", "verbose"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Attach(jvm.PID(), "jcmd", "VM.flags", "-all"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := jvm.Commands()
+	if len(cmds) != 2 {
+		t.Fatalf("commands = %+v", cmds)
+	}
+	if cmds[0].Name != "load" || strings.Join(cmds[0].Args, " ") != "instrument false /opt/agent.jar=verbose" {
+		t.Errorf("load = %+v", cmds[0])
+	}
+	if cmds[1].Key() != "jcmd VM.flags" || cmds[1].Wire != "1\x00jcmd\x00VM.flags\x00-all\x00\x00" {
+		t.Errorf("jcmd = %q", cmds[1].Wire)
+	}
+}
+
+func TestPerfData(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	paths, _ := filepath.Glob(filepath.Join(jvm.TmpPath(), "hsperfdata_*", strconv.Itoa(jvm.PID())))
+	if len(paths) != 1 {
+		t.Fatalf("hsperfdata files = %v", paths)
+	}
+	pd, err := hsperf.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pd.JavaCommand() != "Main" || pd.Frequency() != 1000000000 {
+		t.Errorf("command %q, frequency %d", pd.JavaCommand(), pd.Frequency())
+	}
+	c := pd.Counter("sun.os.hrt.ticks")
+	if c == nil || c.Units != hsperf.UnitsTicks || c.Variability != hsperf.VariabilityMonotonic || pd.Duration(c.Long) != time.Second {
+		t.Errorf("sun.os.hrt.ticks = %+v", c)
+	}
+	if got := pd.Prefix("sun.rt."); len(got) != 2 {
+		t.Errorf("Prefix(sun.rt.) = %d counters, want 2", len(got))
+	}
+}
+
+func TestScriptsParse(t *testing.T) {
+	// The scripted replies are realistic enough for the typed parsers
+	for name, script := range map[string]jattachtest.Script{
+		"HotSpot 8":  jattachtest.HotSpot8(),
+		"HotSpot 17": jattachtest.HotSpot17(),
+		"HotSpot 21": jattachtest.HotSpot21(),
+		"OpenJ9":     jattachtest.OpenJ9(),
+	} {
+		d, err := threaddump.ParseString(script["jcmd Thread.print"].Output)
+		if err != nil || d.VM == "" || d.Find("main") == nil {
+			t.Errorf("%s thread dump = %+v, %v", name, d, err)
+		}
+		h, err := histogram.ParseString(script["jcmd GC.class_histogram"].Output)
+		if err != nil || len(h.Entries) != 3 || h.TotalBytes != 408392 {
+			t.Errorf("%s histogram = %+v, %v", name, h, err)
+		}
+		props := javaprops.Parse(script["properties"].Output)
+		if props["line.separator"] != "\n" || props["path.separator"] != ":" || props["java.version"] == "" {
+			t.Errorf("%s properties = %q", name, props)
+		}
+		agent := javaprops.Parse(script["agentProperties"].Output)
+		if agent["sun.jvm.args"] != "-Xmx1g -XX:+HeapDumpOnOutOfMemoryError" || agent["sun.jvm.flags"] != "" {
+			t.Errorf("%s agent properties = %q", name, agent)
+		}
+	}
+}
+
+func TestOpenJ9RecordsCommands(t *testing.T) {
+	jvm, err := jattachtest.NewOpenJ9(jattachtest.OpenJ9())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	client := jattach.NewClientWithOptions(&jattach.Options{TmpPath: jvm.TmpPath()})
+	if _, err := client.SystemProperties(context.Background(), jvm.PID()); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Attach(jvm.PID(), "jcmd", "Dump.heap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Output, "string_result=Dump written to /tmp/heapdump.phd\\n") {
+		t.Errorf("Dump.heap output = %q", resp.Output)
+	}
+
+	cmds := jvm.Commands()
+	if len(cmds) != 2 || cmds[0].Wire != "ATTACH_GETSYSTEMPROPERTIES" || cmds[1].Wire != "ATTACH_DIAGNOSTICS:Dump.heap" {
+		t.Errorf("commands = %+v", cmds)
+	}
+	if n := jvm.Connections(); n != 2 {
+		t.Errorf("%d connections, want one per attach", n)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	// SIGQUIT to the fake JVM is not sent to the test process
+	for i := 0; i < 3; i++ {
+		if err := jvm.Signal(jvm.PID(), syscall.SIGQUIT); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jvm.Signal(4194000, 0); err != syscall.ESRCH {
+		t.Errorf("Signal() to a missing process = %v, want ESRCH", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := jattach.NewClientWithOptions(&jattach.Options{TmpPath: jvm.TmpPath(), SignalHook: jvm.Signal})
+	if _, err := client.Attach(jvm.PID(), "jcmd", "VM.version"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jvm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := jvm.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	if _, err := os.Stat(jvm.TmpPath()); !os.IsNotExist(err) {
+		t.Errorf("temporary directory left behind: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NewOpenJ9 starts a fake OpenJ9 JVM answering with script
+// Like a real JVM, it publishes attachInfo, waits on the attach semaphore,
+// reads replyInfo and connects back to the attacher with ATTACH_CONNECTED
+// and the key. Commands are served until ATTACH_DETACHED
+// On OpenJ9 the Code of a reply only matters for load; the Output of jcmd
+// replies is sent as the openj9_diagnostics.string_result property
+func NewOpenJ9(script Script) (*Server, error) {
+	s, err := newServer(script)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(s.tmpPath, ".com_ibm_tools_attach", strconv.Itoa(s.pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.Close()
+		return nil, err
+	}
+	attachInfo := fmt.Sprintf("processId=%d\nvmId=%d\ndisplayName=Main\n", s.pid, s.pid)
+	if err := os.WriteFile(filepath.Join(dir, "attachInfo"), []byte(attachInfo), 0600); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "attachNotificationSync"), nil, 0666); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	sem, err := openSemaphore(s.tmpPath)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.runOpenJ9(sem)
+	return s, nil
+}
+
+// runOpenJ9 connects back to each attacher that wrote a new replyInfo
+func (s *Server) runOpenJ9(sem *semaphore) {
+	defer s.wg.Done()
+	defer sem.remove()
+
+	replyInfo := filepath.Join(s.tmpPath, ".com_ibm_tools_attach", strconv.Itoa(s.pid), "replyInfo")
+	lastKey := ""
+	for {
+		if !sem.wait(s.done) {
+			return
+		}
+
+		key, port, ok := readReplyInfo(replyInfo)
+		if !ok || key == lastKey {
+			continue
+		}
+		lastKey = key
+
+		conn, err := dialAttacher(port)
+		if err != nil || !s.trackConn(conn) {
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handleOpenJ9(conn, key)
+		}()
+	}
+}
+
+// readReplyInfo reads the key and port written by the attacher, once the
+// file is complete
+func readReplyInfo(path string) (string, int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", 0, false
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 3 {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(lines[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return lines[0], port, true
+}
+
+// dialAttacher connects to the attacher on the loopback interface
+func dialAttacher(port int) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort("::1", strconv.Itoa(port)), time.Second)
+	}
+	return conn, err
+}
+
+// handleOpenJ9 authenticates and serves NUL terminated commands
+func (s *Server) handleOpenJ9(conn net.Conn, key string) {
+	if _, err := fmt.Fprintf(conn, "ATTACH_CONNECTED %s \x00", key); err != nil {
+		return
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		wire, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		wire = strings.TrimSuffix(wire, "\x00")
+		if wire == "ATTACH_DETACHED" {
+			conn.Write([]byte("ATTACH_ACK\x00"))
+			return
+		}
+
+		cmd := parseOpenJ9Command(wire)
+		unknown := Reply{Raw: true, Output: "ATTACH_ERR unrecognized command " + wire}
+		if cmd.Name == "jcmd" {
+			unknown = Reply{Output: fmt.Sprintf("Error: command %s is not recognized\n", cmd.Args[0])}
+		}
+		reply := s.reply(cmd, unknown)
+
+		var out string
+		switch {
+		case reply.Raw:
+			out = reply.Output
+		case cmd.Name == "load" && reply.Code == 0:
+			out = "ATTACH_ACK"
+		case cmd.Name == "load":
+			out = fmt.Sprintf("ATTACH_ERR AgentInitializationException %d", reply.Code)
+		case cmd.Name == "jcmd":
+			out = fmt.Sprintf("#%s\nopenj9_diagnostics.string_result=%s\nopenj9_diagnostics.result_type=string\n",
+				time.Now().Format(time.UnixDate), escapeProperty(reply.Output))
+		default:
+			out = reply.Output
+		}
+		if _, err := conn.Write([]byte(out + "\x00")); err != nil {
+			return
+		}
+	}
+}
+
+// parseOpenJ9Command maps an OpenJ9 attach command back to the HotSpot
+// command it was translated from
+func parseOpenJ9Command(wire string) Command {
+	cmd := Command{Name: wire, Wire: wire}
+
+	switch {
+	case wire == "ATTACH_GETSYSTEMPROPERTIES":
+		cmd.Name = "properties"
+	case wire == "ATTACH_GETAGENTPROPERTIES":
+		cmd.Name = "agentProperties"
+	case strings.HasPrefix(wire, "ATTACH_DIAGNOSTICS:"):
+		cmd.Name = "jcmd"
+		cmd.Args = trimArgs(strings.Split(strings.TrimPrefix(wire, "ATTACH_DIAGNOSTICS:"), ","))
+		if len(cmd.Args) == 0 {
+			cmd.Args = []string{""}
+		}
+	case strings.HasPrefix(wire, "ATTACH_LOADAGENT"):
+		name, params, _ := strings.Cut(wire, "(")
+		path, options, _ := strings.Cut(strings.TrimSuffix(params, ")"), ",")
+		absolute := strconv.FormatBool(name == "ATTACH_LOADAGENTPATH")
+		cmd.Name = "load"
+		cmd.Args = trimArgs([]string{path, absolute, options})
+	}
+	return cmd
+}
+
+// escapeProperty escapes a value in the java.util.Properties format
+func escapeProperty(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, u := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&sb, `\u%04X`, u)
+				}
+				continue
+			}
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
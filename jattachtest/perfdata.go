@@ -0,0 +1,97 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Units and variability of PerfData entries, as defined by HotSpot
+const (
+	unitsNone   = 1
+	unitsTicks  = 3
+	unitsString = 5
+	unitsHertz  = 6
+
+	variabilityConstant  = 1
+	variabilityMonotonic = 2
+)
+
+// perfCounter is an entry of the fake hsperfdata file
+type perfCounter struct {
+	name        string
+	units       byte
+	variability byte
+	long        int64
+	str         string
+}
+
+// perfCounters are the counters published by the fake HotSpot JVM
+var perfCounters = []perfCounter{
+	{name: "sun.os.hrt.frequency", units: unitsHertz, variability: variabilityConstant, long: 1000000000},
+	{name: "sun.rt.createVmBeginTime", units: unitsNone, variability: variabilityConstant, long: 1700000000000},
+	{name: "sun.rt.javaCommand", units: unitsString, variability: variabilityConstant, str: "Main"},
+	{name: "java.property.java.vm.name", units: unitsString, variability: variabilityConstant, str: "OpenJDK 64-Bit Server VM"},
+	{name: "sun.os.hrt.ticks", units: unitsTicks, variability: variabilityMonotonic, long: 1000000000},
+}
+
+// perfData builds a little-endian PerfData v2.0 memory image
+func perfData() []byte {
+	var entries bytes.Buffer
+	for _, c := range perfCounters {
+		entries.Write(perfEntry(c))
+	}
+
+	const prologueSize = 32
+	prologue := make([]byte, prologueSize)
+	binary.BigEndian.PutUint32(prologue[0:4], 0xcafec0c0)
+	prologue[4] = 1 // little-endian
+	prologue[5] = 2 // major version
+	prologue[6] = 0 // minor version
+	prologue[7] = 1 // accessible
+	binary.LittleEndian.PutUint32(prologue[8:12], uint32(prologueSize+entries.Len()))
+	binary.LittleEndian.PutUint32(prologue[24:28], prologueSize)
+	binary.LittleEndian.PutUint32(prologue[28:32], uint32(len(perfCounters)))
+
+	return append(prologue, entries.Bytes()...)
+}
+
+// perfEntry encodes a PerfDataEntry: a 20 byte header, the NUL terminated
+// name and the value, aligned to 8 bytes
+func perfEntry(c perfCounter) []byte {
+	const headerSize = 20
+	nameOffset := headerSize
+	dataOffset := align8(nameOffset + len(c.name) + 1)
+
+	dataType := byte('J')
+	vectorLength := 0
+	value := make([]byte, 8)
+	if c.units == unitsString {
+		dataType = 'B'
+		vectorLength = len(c.str) + 1
+		value = append([]byte(c.str), 0)
+	} else {
+		binary.LittleEndian.PutUint64(value, uint64(c.long))
+	}
+	length := align8(dataOffset + len(value))
+
+	entry := make([]byte, length)
+	binary.LittleEndian.PutUint32(entry[0:4], uint32(length))
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(nameOffset))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(vectorLength))
+	entry[12] = dataType
+	entry[14] = c.units
+	entry[15] = c.variability
+	binary.LittleEndian.PutUint32(entry[16:20], uint32(dataOffset))
+	copy(entry[nameOffset:], c.name)
+	copy(entry[dataOffset:], value)
+	return entry
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
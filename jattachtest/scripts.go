@@ -0,0 +1,238 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HotSpot8 returns the replies of an OpenJDK 8 JVM
+func HotSpot8() Script {
+	const version = "25.412-b08"
+	threads := `2024-05-01 10:00:00
+Full thread dump OpenJDK 64-Bit Server VM (25.412-b08 mixed mode):
+
+"Attach Listener" #9 daemon prio=9 os_prio=0 tid=0x00007f1c34001000 nid=0x1a2b waiting on condition [0x0000000000000000]
+   java.lang.Thread.State: RUNNABLE
+
+"main" #1 prio=5 os_prio=0 tid=0x00007f1c4c00a800 nid=0x1a01 waiting on condition [0x00007f1c52b7e000]
+   java.lang.Thread.State: TIMED_WAITING (sleeping)
+	at java.lang.Thread.sleep(Native Method)
+	at Main.main(Main.java:12)
+
+"VM Thread" os_prio=0 tid=0x00007f1c4c077000 nid=0x1a05 runnable
+
+JNI global references: 5
+
+`
+	histogram := ` num     #instances         #bytes  class name
+----------------------------------------------
+   1:          3012         250176  [C
+   2:           760          86456  java.lang.Class
+   3:          2990          71760  java.lang.String
+Total          6762         408392
+`
+	flags := `     bool HeapDumpOnOutOfMemoryError                = false                               {manageable}
+    uintx MaxHeapSize                              := 4164943872                          {product}
+     bool PrintConcurrentLocks                      = false                               {manageable}
+     bool UseG1GC                                   = false                               {product}
+     bool UseParallelGC                            := true                                {product}
+`
+
+	s := hotSpot("1.8.0_412", version, threads, histogram, flags)
+	// JDK 8 prints the Agent_OnAttach result alone on the second line
+	s["load"] = Reply{Raw: true, Output: "0\n0\n"}
+	// Unified logging came with JDK 9
+	delete(s, "jcmd VM.log")
+	return s
+}
+
+// HotSpot17 returns the replies of an OpenJDK 17 JVM
+func HotSpot17() Script {
+	threads := `2024-05-01 10:00:00
+Full thread dump OpenJDK 64-Bit Server VM (17.0.11+9 mixed mode, sharing):
+
+Threads class SMR info:
+_java_thread_list=0x00007f6a900025c0, length=2, elements={
+0x00007f6ad8024f10, 0x00007f6a90001000
+}
+
+"main" #1 prio=5 os_prio=0 cpu=120.31ms elapsed=35.10s tid=0x00007f6ad8024f10 nid=0x6d01 waiting on condition  [0x00007f6ade2fe000]
+   java.lang.Thread.State: TIMED_WAITING (sleeping)
+	at java.lang.Thread.sleep(java.base@17.0.11/Native Method)
+	at Main.main(Main.java:12)
+
+"Attach Listener" #14 daemon prio=9 os_prio=0 cpu=0.52ms elapsed=0.10s tid=0x00007f6a90001000 nid=0x6d3a waiting on condition  [0x0000000000000000]
+   java.lang.Thread.State: RUNNABLE
+
+"VM Thread" os_prio=0 cpu=3.12ms elapsed=35.11s tid=0x00007f6ad80b2000 nid=0x6d08 runnable
+
+JNI global refs: 8, weak refs: 0
+
+`
+	return hotSpot("17.0.11", "17.0.11+9", threads, modularHistogram("17.0.11"), modernFlags("G1"))
+}
+
+// HotSpot21 returns the replies of an OpenJDK 21 JVM
+func HotSpot21() Script {
+	threads := `2024-05-01 10:00:00
+Full thread dump OpenJDK 64-Bit Server VM (21.0.3+9-LTS mixed mode, sharing):
+
+Threads class SMR info:
+_java_thread_list=0x00007f6a900025c0, length=3, elements={
+0x00007f6ad8024f10, 0x00007f6a90001000, 0x00007f6a8c002000
+}
+
+"main" #1 [27905] prio=5 os_prio=0 cpu=120.31ms elapsed=35.10s tid=0x00007f6ad8024f10 nid=27905 waiting on condition  [0x00007f6ade2fe000]
+   java.lang.Thread.State: TIMED_WAITING (sleeping)
+	at java.lang.Thread.sleep0(java.base@21.0.3/Native Method)
+	at java.lang.Thread.sleep(java.base@21.0.3/Thread.java:509)
+	at Main.main(Main.java:12)
+
+"ForkJoinPool-1-worker-1" #22 [27960] daemon prio=5 os_prio=0 cpu=2.41ms elapsed=11.68s tid=0x00007f6a8c002000 [0x00007f6a5c4fe000]
+   Carrying virtual thread #21
+	at jdk.internal.vm.Continuation.run(java.base@21.0.3/Continuation.java:251)
+	at java.lang.VirtualThread.runContinuation(java.base@21.0.3/VirtualThread.java:221)
+
+"Attach Listener" #14 [27962] daemon prio=9 os_prio=0 cpu=0.52ms elapsed=0.10s tid=0x00007f6a90001000 nid=27962 waiting on condition  [0x0000000000000000]
+   java.lang.Thread.State: RUNNABLE
+
+"VM Thread" os_prio=0 cpu=3.12ms elapsed=35.11s tid=0x00007f6ad80b2000 nid=27910 runnable
+
+JNI global refs: 8, weak refs: 0
+
+`
+	return hotSpot("21.0.3", "21.0.3+9-LTS", threads, modularHistogram("21.0.3"), modernFlags("G1"))
+}
+
+// OpenJ9 returns the replies of an Eclipse OpenJ9 JVM running Java 17
+func OpenJ9() Script {
+	const version = "17.0.11"
+	threads := `JRE 17 Linux amd64-64-Bit Compressed References 20240416_741 (JIT enabled, AOT enabled)
+OpenJ9   - b04a1f1
+OMR      - 2c46f2b
+
+"main" prio=5 Id=1 TIMED_WAITING
+	at java.base@17.0.11/java.lang.Thread.sleepImpl(Native Method)
+	at java.base@17.0.11/java.lang.Thread.sleep(Thread.java:1000)
+	at app//Main.main(Main.java:12)
+
+"Attach API wait loop" daemon prio=10 Id=12 RUNNABLE
+	at java.base@17.0.11/openj9.internal.tools.attach.target.IPC.waitSemaphore(Native Method)
+
+`
+	histogram := `num   object count  total size    class name
+-------------------------------------------------
+  1          3012      250176    [B
+  2          2990       71760    java.lang.String
+  3           760       86456    java.lang.Class
+-------------------------------------------------
+Total        6762      408392
+`
+
+	return Script{
+		"properties":              {Output: systemProperties(version, "Eclipse OpenJ9 VM", "Eclipse OpenJ9")},
+		"agentProperties":         {Output: agentProperties("-Xmx1g -XX:+HeapDumpOnOutOfMemoryError")},
+		"load":                    {},
+		"jcmd Thread.print":       {Output: threads},
+		"jcmd GC.class_histogram": {Output: histogram},
+		"jcmd Dump.heap":          {Output: "Dump written to /tmp/heapdump.phd\n"},
+		"jcmd Dump.java":          {Output: "Dump written to /tmp/javacore.txt\n"},
+		"jcmd GC.heap_dump":       {Output: "Dump written to /tmp/heapdump.phd\n"},
+		"jcmd VM.version":         {Output: "JRE 17 Linux amd64-64-Bit Compressed References 20240416_741 (JIT enabled, AOT enabled)\nOpenJ9   - b04a1f1\nOMR      - 2c46f2b\n"},
+		"jcmd help":               {Output: "Available commands:\nDump.heap\nDump.java\nGC.class_histogram\nGC.run\nThread.print\nVM.version\nhelp\n"},
+	}
+}
+
+// hotSpot builds the script shared by the HotSpot versions
+func hotSpot(javaVersion, vmVersion, threads, histogram, flags string) Script {
+	version := fmt.Sprintf("OpenJDK 64-Bit Server VM version %s\nJDK %s\n", vmVersion, javaVersion)
+	props := systemProperties(javaVersion, "OpenJDK 64-Bit Server VM", "Oracle Corporation")
+
+	return Script{
+		"properties":      {Output: props},
+		"agentProperties": {Output: agentProperties("-Xmx1g -XX:+HeapDumpOnOutOfMemoryError")},
+		"threaddump":      {Output: threads},
+		"inspectheap":     {Output: histogram},
+		"dumpheap":        {Output: "Heap dump file created\n"},
+		"datadump":        {Output: threads},
+		"load":            {},
+		"setflag":         {},
+		"printflag":       {Output: "-XX:-HeapDumpOnOutOfMemoryError\n"},
+
+		"jcmd Thread.print":         {Output: threads},
+		"jcmd GC.class_histogram":   {Output: histogram},
+		"jcmd GC.heap_dump":         {Output: "Heap dump file created\n"},
+		"jcmd GC.heap_info":         {Output: " garbage-first heap   total 262144K, used 10240K [0x00000000f0000000, 0x0000000100000000)\n"},
+		"jcmd GC.run":               {},
+		"jcmd VM.version":           {Output: version},
+		"jcmd VM.flags":             {Output: flags},
+		"jcmd VM.system_properties": {Output: props},
+		"jcmd VM.uptime":            {Output: "35.112 s\n"},
+		"jcmd VM.log":               {Output: logList},
+		"jcmd help":                 {Output: "The following commands are available:\nGC.class_histogram\nGC.heap_dump\nGC.heap_info\nGC.run\nThread.print\nVM.flags\nVM.log\nVM.system_properties\nVM.uptime\nVM.version\nhelp\n"},
+	}
+}
+
+// modularHistogram is a class histogram of JDK 9 and later, which print
+// the module of each class
+func modularHistogram(version string) string {
+	return fmt.Sprintf(` num     #instances         #bytes  class name (module)
+-------------------------------------------------------
+   1:          3012         250176  [B (java.base@%[1]s)
+   2:           760          86456  java.lang.Class (java.base@%[1]s)
+   3:          2990          71760  java.lang.String (java.base@%[1]s)
+Total          6762         408392
+`, version)
+}
+
+// modernFlags is the output of VM.flags -all on JDK 9 and later
+func modernFlags(gc string) string {
+	return fmt.Sprintf(`     bool HeapDumpOnOutOfMemoryError                = false                                  {manageable} {default}
+   size_t MaxHeapSize                              = 4164943872                             {product} {ergonomic}
+     bool PrintConcurrentLocks                     = false                                  {manageable} {default}
+     bool Use%[1]sGC                                  = true                                   {product} {ergonomic}
+     bool UseParallelGC                            = false                                  {product} {default}
+`, gc)
+}
+
+// logList is the output of VM.log list
+const logList = `Available log levels: off, trace, debug, info, warning, error
+Available log decorators: time (t), utctime (utc), uptime (u), timemillis (tm), uptimemillis (um), timenanos (tn), uptimenanos (un), hostname (hn), pid (p), tid (ti), level (l), tags (tg)
+Log output configuration:
+ #0: stdout all=warning uptime,level,tags
+ #1: stderr all=off uptime,level,tags
+`
+
+// systemProperties returns the properties reply, in the
+// java.util.Properties format
+func systemProperties(version, vmName, vendor string) string {
+	props := []string{
+		"#Wed May 01 10:00:00 UTC 2024",
+		"java.version=" + version,
+		"java.vm.name=" + vmName,
+		"java.vendor=" + vendor,
+		"java.home=/usr/lib/jvm/java",
+		"os.name=Linux",
+		"user.dir=/app",
+		"file.separator=/",
+		"line.separator=\\n",
+		"path.separator=\\:",
+	}
+	return strings.Join(props, "\n") + "\n"
+}
+
+// agentProperties returns the agentProperties reply
+func agentProperties(args string) string {
+	props := []string{
+		"#Wed May 01 10:00:00 UTC 2024",
+		"sun.java.command=Main",
+		"sun.jvm.args=" + args,
+		"sun.jvm.flags=",
+	}
+	return strings.Join(props, "\n") + "\n"
+}
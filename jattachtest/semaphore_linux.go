@@ -0,0 +1,91 @@
+//go:build linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// semaphore is the System V semaphore OpenJ9 JVMs wait on for attachers
+type semaphore struct {
+	id    uintptr
+	valid bool
+}
+
+type sembuf struct {
+	SemNum uint16
+	SemOp  int16
+	SemFlg int16
+}
+
+// openSemaphore creates the semaphore of the _notifier file, with the key
+// derived by ftok(3) like OpenJ9 does. Without System V IPC the fake JVM
+// polls for replyInfo instead
+func openSemaphore(tmpPath string) (*semaphore, error) {
+	path := filepath.Join(tmpPath, ".com_ibm_tools_attach", "_notifier")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return nil, err
+	}
+	key := 0xa1<<24 | int(st.Dev&0xff)<<16 | int(st.Ino&0xffff)
+
+	id, _, errno := syscall.Syscall(unix.SYS_SEMGET, uintptr(key), 1, 0666|unix.IPC_CREAT)
+	if errno != 0 {
+		return &semaphore{}, nil
+	}
+	return &semaphore{id: id, valid: true}, nil
+}
+
+// wait returns true once the semaphore has been posted, or false when done
+// is closed
+func (s *semaphore) wait(done <-chan struct{}) bool {
+	for {
+		if !s.valid {
+			// Nothing to wait on, look for replyInfo every time
+			return sleep(done)
+		}
+
+		op := sembuf{SemOp: -1, SemFlg: int16(unix.IPC_NOWAIT)}
+		_, _, errno := syscall.Syscall(unix.SYS_SEMOP, s.id, uintptr(unsafe.Pointer(&op)), 1)
+		if errno == 0 {
+			return true
+		}
+		if !sleep(done) {
+			return false
+		}
+	}
+}
+
+// remove deletes the semaphore
+func (s *semaphore) remove() {
+	if s.valid {
+		syscall.Syscall(unix.SYS_SEMCTL, s.id, 0, unix.IPC_RMID)
+	}
+}
+
+// sleep waits for the next poll, returning false when done is closed
+func sleep(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	case <-time.After(10 * time.Millisecond):
+		return true
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattachtest
+
+import "time"
+
+// semaphore stands for the OpenJ9 attach semaphore where System V IPC is
+// not used: the fake JVM polls for replyInfo instead
+type semaphore struct{}
+
+func openSemaphore(tmpPath string) (*semaphore, error) {
+	return &semaphore{}, nil
+}
+
+// wait returns true at the next poll, or false when done is closed
+func (s *semaphore) wait(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	case <-time.After(10 * time.Millisecond):
+		return true
+	}
+}
+
+func (s *semaphore) remove() {}
@@ -0,0 +1,234 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxs-2/jattach-go/histogram"
+	"github.com/xxs-2/jattach-go/internal/javaprops"
+	"github.com/xxs-2/jattach-go/threaddump"
+)
+
+// jsonRecord is the JSON schema shared by Response and Result
+//
+//	{
+//	  "pid": 1234,
+//	  "nspid": 1,
+//	  "jvm_type": "HotSpot",
+//	  "command": "jcmd",
+//	  "args": ["VM.flags -all"],
+//	  "code": 0,
+//	  "timings": [{"phase": "resolve", "ms": 0.31}, {"phase": "connect", "ms": 0.05}],
+//	  "duration_ms": 12.6,
+//	  "body": [{"name": "UseG1GC", "type": "bool", "value": "true", ...}],
+//	  "error": {"op": "wait_socket", "kind": "timeout", "message": "timeout"}
+//	}
+//
+// "output" holds the raw response instead of "body" when the command has no
+// typed parser. "code", "output" and "body" are absent when the attach
+// failed, "error" when it succeeded
+type jsonRecord struct {
+	PID        int          `json:"pid"`
+	NsPID      int          `json:"nspid,omitempty"`
+	JVMType    string       `json:"jvm_type,omitempty"`
+	Command    string       `json:"command"`
+	Args       []string     `json:"args"`
+	Code       *int         `json:"code,omitempty"`
+	Timings    []jsonTiming `json:"timings,omitempty"`
+	DurationMS float64      `json:"duration_ms,omitempty"`
+	Output     *string      `json:"output,omitempty"`
+	Body       any          `json:"body,omitempty"`
+	Error      *jsonError   `json:"error,omitempty"`
+}
+
+type jsonTiming struct {
+	Phase string  `json:"phase"`
+	MS    float64 `json:"ms"`
+}
+
+// jsonError is an attach failure. Kind is the message of the sentinel error
+// it matches (e.g. "process not found"), empty if none
+type jsonError struct {
+	Op      string `json:"op,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	Message string `json:"message"`
+}
+
+// MarshalJSON encodes the response with a stable schema: pid, nspid,
+// jvm_type, command, args, code, timings and duration_ms, and either the
+// raw output or, for commands with a typed parser, the parsed body
+// Thread dumps (threaddump, jcmd Thread.print) are encoded as a
+// threaddump.ThreadDump, class histograms (inspectheap, jcmd
+// GC.class_histogram) as a histogram.Histogram, properties as an object
+// and jcmd VM.flags -all as a list of Flag
+// To keep <, > and & unescaped, use an Encoder with SetEscapeHTML(false)
+// as NDJSONWriter does: json.Marshal escapes them
+func (r Response) MarshalJSON() ([]byte, error) {
+	return marshalRecord(r.record())
+}
+
+func (r *Response) record() *jsonRecord {
+	rec := &jsonRecord{
+		PID:     r.PID,
+		NsPID:   r.NsPID,
+		JVMType: r.JVMType.String(),
+		Command: r.Command,
+		Args:    r.Args,
+		Code:    &r.Code,
+	}
+	if rec.Args == nil {
+		rec.Args = []string{}
+	}
+
+	var total time.Duration
+	for _, t := range r.Timings {
+		rec.Timings = append(rec.Timings, jsonTiming{Phase: t.Phase, MS: milliseconds(t.Duration)})
+		total += t.Duration
+	}
+	rec.DurationMS = milliseconds(total)
+
+	if body := r.parsedBody(); body != nil {
+		rec.Body = body
+	} else {
+		rec.Output = &r.Output
+	}
+	return rec
+}
+
+// parsedBody parses the output of a successful command that has a typed
+// parser, and returns nil otherwise
+func (r *Response) parsedBody() any {
+	if r.Code != 0 {
+		return nil
+	}
+
+	// HotSpot output starts with the return code line
+	output := r.Output
+	if r.JVMType != JVMTypeOpenJ9 {
+		_, output, _ = strings.Cut(output, "\n")
+	}
+
+	name := r.Command
+	if name == CmdJCmd && len(r.Args) > 0 {
+		fields := strings.Fields(strings.Join(r.Args, " "))
+		if len(fields) == 0 {
+			return nil
+		}
+		name = fields[0]
+	}
+
+	switch name {
+	case CmdThreadDump, "Thread.print":
+		if d, err := threaddump.ParseString(output); err == nil && len(d.Threads) > 0 {
+			return d
+		}
+	case CmdInspectHeap, "GC.class_histogram":
+		if h, err := histogram.ParseString(output); err == nil {
+			return h
+		}
+	case CmdProperties, CmdAgentProperties, "VM.system_properties":
+		if props := javaprops.Parse(output); len(props) > 0 {
+			return props
+		}
+	case "VM.flags":
+		if flags := parseFlags(output); len(flags) > 0 {
+			return flags
+		}
+	}
+	return nil
+}
+
+// Result is the outcome of an attach to one target, with the same JSON
+// schema as Response plus the structured error of a failed attach
+type Result struct {
+	PID     int
+	Command string
+	Args    []string
+
+	// Response is nil if the attach failed
+	Response *Response
+
+	// Err is the attach error, usually an *AttachError
+	Err error
+}
+
+// MarshalJSON encodes the result like Response.MarshalJSON, with an
+// "error" object holding the operation, kind and message of Err
+func (r Result) MarshalJSON() ([]byte, error) {
+	rec := &jsonRecord{PID: r.PID, Command: r.Command, Args: r.Args}
+	if r.Response != nil {
+		rec = r.Response.record()
+	}
+	if rec.Args == nil {
+		rec.Args = []string{}
+	}
+
+	if r.Err != nil {
+		rec.Error = &jsonError{Kind: errorKind(r.Err), Message: r.Err.Error()}
+		var attachErr *AttachError
+		if errors.As(r.Err, &attachErr) {
+			rec.Error.Op = attachErr.Op
+			rec.Error.Message = attachErr.Err.Error()
+			if rec.PID == 0 {
+				rec.PID = attachErr.PID
+			}
+		}
+	}
+	return marshalRecord(rec)
+}
+
+// NDJSONWriter writes results as newline-delimited JSON, one object per
+// line. It is safe for concurrent use, so results of a multi-target run
+// can be written as they complete
+type NDJSONWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewNDJSONWriter returns a writer of NDJSON results to w
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{w: w}
+}
+
+// Write writes one result as a single line
+func (w *NDJSONWriter) Write(r *Result) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.w.Write(buf.Bytes())
+	return err
+}
+
+// marshalRecord encodes without escaping <, > and &, which are common in
+// thread dumps and class names. This only lasts if the caller's encoder
+// does not escape HTML either: json.Marshal, and an Encoder without
+// SetEscapeHTML(false), escape the output of MarshalJSON again
+func marshalRecord(rec *jsonRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+// record is the part of the JSON schema checked by the tests
+type record struct {
+	PID        int             `json:"pid"`
+	NsPID      int             `json:"nspid"`
+	JVMType    string          `json:"jvm_type"`
+	Command    string          `json:"command"`
+	Args       []string        `json:"args"`
+	Code       *int            `json:"code"`
+	DurationMS float64         `json:"duration_ms"`
+	Output     *string         `json:"output"`
+	Body       json.RawMessage `json:"body"`
+	Error      *struct {
+		Op      string `json:"op"`
+		Kind    string `json:"kind"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func decodeRecord(t *testing.T, v any) record {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	return rec
+}
+
+const threadDump = "0\n" + `Full thread dump OpenJDK 64-Bit Server VM (17.0.11+9 mixed mode, sharing):
+
+"main" #1 prio=5 os_prio=0 tid=0x00007f6ad8024f10 nid=0x6d01 waiting on condition  [0x00007f6ade2fe000]
+   java.lang.Thread.State: TIMED_WAITING (sleeping)
+	at java.lang.Thread.sleep(java.base@17.0.11/Native Method)
+	at Main.main(Main.java:12)
+
+`
+
+func TestResponseJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		resp jattach.Response
+		body string // Substring of the body, empty for the raw output
+	}{
+		{"threaddump", jattach.Response{JVMType: jattach.JVMTypeHotSpot, Command: "threaddump", Output: threadDump},
+			`"name":"main"`},
+		{"OpenJ9 properties", jattach.Response{JVMType: jattach.JVMTypeOpenJ9, Command: "properties", Output: "java.version=17.0.11\nline.separator=\\n\n"},
+			`"line.separator":"\n"`},
+		{"VM.flags", jattach.Response{JVMType: jattach.JVMTypeHotSpot, Command: "jcmd", Args: []string{"VM.flags -all"},
+			Output: "0\n     bool UseG1GC                                  = true                                   {product} {ergonomic}\n"},
+			`"name":"UseG1GC"`},
+		{"no parser", jattach.Response{JVMType: jattach.JVMTypeHotSpot, Command: "jcmd", Args: []string{"VM.uptime"}, Output: "0\n35.112 s\n"}, ""},
+		{"failed", jattach.Response{JVMType: jattach.JVMTypeHotSpot, Command: "threaddump", Code: 1, Output: "1\nfailed\n"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.resp.PID = 1234
+			tt.resp.Timings = []jattach.Timing{{Phase: "resolve", Duration: time.Millisecond}, {Phase: "connect", Duration: 500 * time.Microsecond}}
+
+			rec := decodeRecord(t, tt.resp)
+			if rec.PID != 1234 || rec.JVMType != tt.resp.JVMType.String() || rec.Command != tt.resp.Command || rec.Args == nil {
+				t.Errorf("record = %+v", rec)
+			}
+			if rec.Code == nil || *rec.Code != tt.resp.Code || rec.DurationMS != 1.5 || rec.Error != nil {
+				t.Errorf("record = %+v", rec)
+			}
+
+			if tt.body == "" {
+				if rec.Body != nil || rec.Output == nil || *rec.Output != tt.resp.Output {
+					t.Errorf("record has output %v, body %s", rec.Output, rec.Body)
+				}
+				return
+			}
+			if rec.Output != nil || !strings.Contains(string(rec.Body), tt.body) {
+				t.Errorf("record has output %v, body %s", rec.Output, rec.Body)
+			}
+		})
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	err := &jattach.AttachError{Op: "find_process", PID: 4321, Err: fmt.Errorf("%w: 4321", jattach.ErrProcessNotFound)}
+	rec := decodeRecord(t, jattach.Result{Command: "jcmd", Args: []string{"VM.version"}, Err: err})
+	if rec.PID != 4321 || rec.Code != nil || rec.Output != nil || rec.Command != "jcmd" || len(rec.Args) != 1 {
+		t.Errorf("record = %+v", rec)
+	}
+	if rec.Error == nil || rec.Error.Op != "find_process" || rec.Error.Kind != jattach.ErrProcessNotFound.Error() || rec.Error.Message != "process not found: 4321" {
+		t.Errorf("error = %+v", rec.Error)
+	}
+
+	resp := &jattach.Response{PID: 1234, Command: "jcmd", Args: []string{"VM.uptime"}, Output: "0\n35.112 s\n"}
+	rec = decodeRecord(t, jattach.Result{PID: 1234, Command: "jcmd", Response: resp})
+	if rec.PID != 1234 || rec.Code == nil || rec.Output == nil || rec.Error != nil {
+		t.Errorf("record = %+v", rec)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := jattach.NewNDJSONWriter(&buf)
+	for _, output := range []string{"0\n<init> & more\n", "0\n"} {
+		resp := &jattach.Response{Command: "jcmd", Args: []string{"VM.uptime"}, Output: output}
+		if err := w.Write(&jattach.Result{Response: resp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "<init> & more") {
+		t.Errorf("output = %q", buf.String())
+	}
+}
+
+func TestAttachJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		openj9 bool
+		cmd    string
+		args   []string
+		body   func(t *testing.T, body json.RawMessage)
+	}{
+		{"threaddump", false, "threaddump", nil, func(t *testing.T, body json.RawMessage) {
+			var d struct {
+				VM      string `json:"vm"`
+				Threads []struct {
+					Name  string `json:"name"`
+					State string `json:"state"`
+				} `json:"threads"`
+			}
+			json.Unmarshal(body, &d)
+			if len(d.Threads) != 3 || d.Threads[0].Name != "main" || d.Threads[0].State != "TIMED_WAITING" {
+				t.Errorf("thread dump = %s", body)
+			}
+		}},
+		{"OpenJ9 Thread.print", true, "jcmd", []string{"Thread.print"}, func(t *testing.T, body json.RawMessage) {
+			var d struct {
+				Threads []struct {
+					Name string `json:"name"`
+				} `json:"threads"`
+			}
+			json.Unmarshal(body, &d)
+			if len(d.Threads) != 2 || d.Threads[1].Name != "Attach API wait loop" {
+				t.Errorf("thread dump = %s", body)
+			}
+		}},
+		{"GC.class_histogram", false, "jcmd", []string{"GC.class_histogram"}, func(t *testing.T, body json.RawMessage) {
+			var h struct {
+				Entries    []struct{ Class string } `json:"entries"`
+				TotalBytes int64                    `json:"total_bytes"`
+			}
+			json.Unmarshal(body, &h)
+			if len(h.Entries) != 3 || h.Entries[0].Class != "[B" || h.TotalBytes != 408392 {
+				t.Errorf("histogram = %s", body)
+			}
+		}},
+		{"properties", false, "properties", nil, func(t *testing.T, body json.RawMessage) {
+			var props map[string]string
+			json.Unmarshal(body, &props)
+			if props["java.version"] != "17.0.11" {
+				t.Errorf("properties = %s", body)
+			}
+		}},
+		{"VM.flags", false, "jcmd", []string{"VM.flags -all"}, func(t *testing.T, body json.RawMessage) {
+			var flags []jattach.Flag
+			json.Unmarshal(body, &flags)
+			if len(flags) != 5 || flags[2].Name != "PrintConcurrentLocks" || !flags[2].Manageable {
+				t.Errorf("flags = %s", body)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var client *jattach.Client
+			var jvm *jattachtest.Server
+			jvmType := "HotSpot"
+			if tt.openj9 {
+				client, jvm = newOpenJ9(t, jattachtest.OpenJ9())
+				jvmType = "OpenJ9"
+			} else {
+				client, jvm = newHotSpot(t, jattachtest.HotSpot17())
+			}
+
+			resp, err := client.Attach(jvm.PID(), tt.cmd, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := decodeRecord(t, resp)
+			if rec.PID != jvm.PID() || rec.JVMType != jvmType || rec.Command != tt.cmd || rec.Code == nil || *rec.Code != 0 {
+				t.Errorf("record = %+v", rec)
+			}
+			if rec.Output != nil || rec.Body == nil {
+				t.Fatalf("record has output %v, body %s", rec.Output, rec.Body)
+			}
+			tt.body(t, rec.Body)
+		})
+	}
+}
+
+func TestAttachJSONOutput(t *testing.T) {
+	client, jvm := newHotSpot(t, jattachtest.HotSpot17())
+
+	// Commands without a parser and failed commands keep the raw output
+	for _, args := range [][]string{{"VM.uptime"}, {"Thread.dump_to_file"}} {
+		resp, err := client.Attach(jvm.PID(), "jcmd", args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := decodeRecord(t, resp)
+		if rec.Body != nil || rec.Output == nil || *rec.Output != resp.Output {
+			t.Errorf("%s: record = %+v", args[0], rec)
+		}
+	}
+}
+
+func TestAttachManyJSON(t *testing.T) {
+	client := jattach.NewClientWithOptions(&jattach.Options{TmpPath: t.TempDir()})
+	results := client.AttachMany(t.Context(), []int{-1}, "jcmd", []string{"VM.version"}, nil)
+
+	var buf bytes.Buffer
+	w := jattach.NewNDJSONWriter(&buf)
+	for r := range results {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("output = %q", buf.String())
+	}
+	var rec record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Error == nil || rec.Error.Kind != jattach.ErrProcessNotFound.Error() || rec.Code != nil {
+		t.Errorf("record = %s", lines[0])
+	}
+	if rec.Command != "jcmd" || len(rec.Args) != 1 || rec.Args[0] != "VM.version" {
+		t.Errorf("record = %s", lines[0])
+	}
+}
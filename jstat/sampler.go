@@ -0,0 +1,91 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jstat
+
+import (
+	"context"
+	"time"
+
+	"github.com/xxs-2/jattach-go/hsperf"
+)
+
+// Sampler takes snapshots of one JVM at a fixed interval
+type Sampler struct {
+	// PID is the host PID of the JVM
+	PID int
+
+	// ProcRoot is where the host procfs is mounted, e.g. /host/proc in a
+	// sidecar (default: JATTACH_PROC_ROOT environment variable, or /proc)
+	ProcRoot string
+
+	// Interval between samples (default: 1 second)
+	Interval time.Duration
+
+	// Count limits the number of samples, zero means until canceled
+	Count int
+
+	err error
+}
+
+// NewSampler creates a sampler for the given JVM
+func NewSampler(pid int, interval time.Duration) *Sampler {
+	return &Sampler{PID: pid, Interval: interval}
+}
+
+// Start samples the JVM until ctx is done, Count samples were taken or the
+// JVM goes away. The first sample is taken immediately. The channel is
+// closed when sampling stops; Err then reports why, if not for ctx or Count
+func (s *Sampler) Start(ctx context.Context) <-chan *Snapshot {
+	ch := make(chan *Snapshot)
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	go func() {
+		defer close(ch)
+
+		// Locate the file once; the JVM keeps it at the same path
+		path, err := hsperf.FindAt(s.ProcRoot, s.PID)
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for n := 0; s.Count == 0 || n < s.Count; n++ {
+			if n > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+
+			pd, err := hsperf.ReadFile(path)
+			if err != nil {
+				s.err = err
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- FromPerfData(pd):
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Err returns the error that stopped sampling, once the channel returned
+// by Start is closed
+func (s *Sampler) Err() error {
+	return s.err
+}
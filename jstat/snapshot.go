@@ -0,0 +1,183 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package jstat computes the statistics of the JDK jstat tool from the
+// hsperfdata counters of a HotSpot JVM, without needing a JDK in the
+// target's container
+package jstat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xxs-2/jattach-go/hsperf"
+)
+
+// Snapshot holds the statistics of one sample
+type Snapshot struct {
+	// Time is when the sample was taken
+	Time time.Time
+
+	// Uptime is the JVM uptime at the sample (the -t Timestamp column)
+	Uptime time.Duration
+
+	GC       GCStats
+	Class    ClassStats
+	Compiler CompilerStats
+}
+
+// GCStats holds heap space sizes and collector statistics (jstat -gc)
+// Sizes are in bytes. Counters not published by the JVM are -1
+type GCStats struct {
+	S0C, S0U   int64 // Survivor space 0 capacity and usage
+	S1C, S1U   int64 // Survivor space 1 capacity and usage
+	EC, EU     int64 // Eden capacity and usage
+	OC, OU     int64 // Old generation capacity and usage
+	MC, MU     int64 // Metaspace capacity and usage
+	CCSC, CCSU int64 // Compressed class space capacity and usage
+
+	YGC  int64         // Young collections
+	YGCT time.Duration // Time spent in young collections
+	FGC  int64         // Full collections
+	FGCT time.Duration // Time spent in full collections
+	CGC  int64         // Concurrent collections, -1 if no such collector
+	CGCT time.Duration // Time spent in concurrent collections
+	GCT  time.Duration // Total collection time
+
+	LastCause string // Cause of the last collection (LGCC)
+	Cause     string // Cause of the current collection (GCC)
+}
+
+// GCUtil holds space utilization in percent (jstat -gcutil)
+// Spaces that do not exist are reported as -1
+type GCUtil struct {
+	S0, S1, E, O, M, CCS float64
+}
+
+// Util computes the space utilization from the space sizes
+func (g *GCStats) Util() GCUtil {
+	return GCUtil{
+		S0:  percent(g.S0U, g.S0C),
+		S1:  percent(g.S1U, g.S1C),
+		E:   percent(g.EU, g.EC),
+		O:   percent(g.OU, g.OC),
+		M:   percent(g.MU, g.MC),
+		CCS: percent(g.CCSU, g.CCSC),
+	}
+}
+
+// ClassStats holds class loader statistics (jstat -class)
+type ClassStats struct {
+	Loaded        int64         // Classes loaded
+	LoadedBytes   int64         // Bytes of classes loaded
+	Unloaded      int64         // Classes unloaded
+	UnloadedBytes int64         // Bytes of classes unloaded
+	Time          time.Duration // Time spent loading and unloading
+}
+
+// CompilerStats holds JIT compiler statistics (jstat -compiler)
+type CompilerStats struct {
+	Compiled     int64         // Compilation tasks performed
+	Failed       int64         // Compilation tasks that failed
+	Invalid      int64         // Compilation tasks invalidated
+	Time         time.Duration // Time spent compiling
+	FailedType   int64         // Compile type of the last failure
+	FailedMethod string        // Method of the last failure
+}
+
+// FromPerfData computes a snapshot from parsed PerfData
+func FromPerfData(pd *hsperf.PerfData) *Snapshot {
+	s := &Snapshot{Time: time.Now()}
+
+	long := func(name string) int64 {
+		v, ok := pd.LongValue(name)
+		if !ok {
+			return -1
+		}
+		return v
+	}
+	sum := func(names ...string) int64 {
+		var total int64
+		for _, name := range names {
+			if v, ok := pd.LongValue(name); ok {
+				total += v
+			}
+		}
+		return total
+	}
+	ticks := func(name string) time.Duration {
+		v, ok := pd.LongValue(name)
+		if !ok {
+			return 0
+		}
+		return pd.Duration(v)
+	}
+	str := func(name string) string {
+		v, _ := pd.StringValue(name)
+		return v
+	}
+
+	s.Uptime = ticks("sun.os.hrt.ticks")
+
+	g := &s.GC
+	g.EC = long("sun.gc.generation.0.space.0.capacity")
+	g.EU = long("sun.gc.generation.0.space.0.used")
+	g.S0C = long("sun.gc.generation.0.space.1.capacity")
+	g.S0U = long("sun.gc.generation.0.space.1.used")
+	g.S1C = long("sun.gc.generation.0.space.2.capacity")
+	g.S1U = long("sun.gc.generation.0.space.2.used")
+	g.OC = long("sun.gc.generation.1.space.0.capacity")
+	g.OU = long("sun.gc.generation.1.space.0.used")
+	g.MC = long("sun.gc.metaspace.capacity")
+	g.MU = long("sun.gc.metaspace.used")
+	g.CCSC = long("sun.gc.compressedclassspace.capacity")
+	g.CCSU = long("sun.gc.compressedclassspace.used")
+
+	g.YGC = long("sun.gc.collector.0.invocations")
+	g.YGCT = ticks("sun.gc.collector.0.time")
+	g.FGC = long("sun.gc.collector.1.invocations")
+	g.FGCT = ticks("sun.gc.collector.1.time")
+	g.CGC = long("sun.gc.collector.2.invocations")
+	g.CGCT = ticks("sun.gc.collector.2.time")
+	g.GCT = g.YGCT + g.FGCT + g.CGCT
+
+	g.LastCause = str("sun.gc.lastCause")
+	g.Cause = str("sun.gc.cause")
+
+	c := &s.Class
+	c.Loaded = sum("java.cls.loadedClasses", "java.cls.sharedLoadedClasses")
+	c.LoadedBytes = sum("sun.cls.loadedBytes", "sun.cls.sharedLoadedBytes")
+	c.Unloaded = sum("java.cls.unloadedClasses", "java.cls.sharedUnloadedClasses")
+	c.UnloadedBytes = sum("sun.cls.unloadedBytes", "sun.cls.sharedUnloadedBytes")
+	c.Time = ticks("sun.cls.time")
+
+	comp := &s.Compiler
+	comp.Compiled = long("sun.ci.totalCompiles")
+	comp.Failed = long("sun.ci.totalBailouts")
+	comp.Invalid = long("sun.ci.totalInvalidates")
+	comp.Time = ticks("java.ci.totalTime")
+	comp.FailedType = long("sun.ci.lastFailedType")
+	comp.FailedMethod = str("sun.ci.lastFailedMethod")
+
+	return s
+}
+
+// percent returns used as a percentage of capacity, or -1 if unknown
+func percent(used, capacity int64) float64 {
+	if used < 0 || capacity <= 0 {
+		if capacity == 0 && used == 0 {
+			return 0
+		}
+		return -1
+	}
+	return float64(used) * 100 / float64(capacity)
+}
+
+// String returns a one-line summary of the snapshot
+func (s *Snapshot) String() string {
+	u := s.GC.Util()
+	return fmt.Sprintf("E=%.2f%% O=%.2f%% M=%.2f%% YGC=%d FGC=%d GCT=%.3fs",
+		u.E, u.O, u.M, s.GC.YGC, s.GC.FGC, s.GC.GCT.Seconds())
+}
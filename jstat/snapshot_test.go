@@ -0,0 +1,132 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jstat
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go/hsperf"
+)
+
+// perfData builds PerfData holding counters, which are int64 or string
+// values, in the little-endian PerfData v2 layout
+func perfData(t *testing.T, counters map[string]any) *hsperf.PerfData {
+	t.Helper()
+
+	var body []byte
+	for name, v := range counters {
+		nameBytes := append([]byte(name), 0)
+		for (20+len(nameBytes))%8 != 0 {
+			nameBytes = append(nameBytes, 0)
+		}
+		var typ byte
+		var value []byte
+		vector := 0
+		switch v := v.(type) {
+		case int64:
+			typ = 'J'
+			value = binary.LittleEndian.AppendUint64(nil, uint64(v))
+		case string:
+			typ = 'B'
+			value = append([]byte(v), 0)
+			vector = len(value)
+		}
+
+		b := binary.LittleEndian.AppendUint32(nil, uint32(20+len(nameBytes)+len(value)))
+		b = binary.LittleEndian.AppendUint32(b, 20)
+		b = binary.LittleEndian.AppendUint32(b, uint32(vector))
+		b = append(b, typ, 0, 0, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(20+len(nameBytes)))
+		b = append(b, nameBytes...)
+		body = append(body, append(b, value...)...)
+	}
+
+	b := []byte{0xca, 0xfe, 0xc0, 0xc0, 1, 2, 0, 1}
+	b = binary.LittleEndian.AppendUint32(b, uint32(32+len(body)))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint64(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, 32)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(counters)))
+
+	pd, err := hsperf.Parse(append(b, body...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pd
+}
+
+func TestFromPerfData(t *testing.T) {
+	const mb = 1024 * 1024
+	pd := perfData(t, map[string]any{
+		"sun.os.hrt.frequency":                 int64(1000),
+		"sun.os.hrt.ticks":                     int64(35000),
+		"sun.gc.generation.0.space.0.capacity": int64(64 * mb),
+		"sun.gc.generation.0.space.0.used":     int64(16 * mb),
+		"sun.gc.generation.0.space.1.capacity": int64(8 * mb),
+		"sun.gc.generation.0.space.1.used":     int64(0),
+		"sun.gc.generation.0.space.2.capacity": int64(8 * mb),
+		"sun.gc.generation.0.space.2.used":     int64(2 * mb),
+		"sun.gc.generation.1.space.0.capacity": int64(128 * mb),
+		"sun.gc.generation.1.space.0.used":     int64(96 * mb),
+		"sun.gc.metaspace.capacity":            int64(32 * mb),
+		"sun.gc.metaspace.used":                int64(24 * mb),
+		"sun.gc.collector.0.invocations":       int64(12),
+		"sun.gc.collector.0.time":              int64(250),
+		"sun.gc.collector.1.invocations":       int64(1),
+		"sun.gc.collector.1.time":              int64(750),
+		"sun.gc.lastCause":                     "G1 Evacuation Pause",
+		"sun.gc.cause":                         "No GC",
+		"java.cls.loadedClasses":               int64(3000),
+		"java.cls.sharedLoadedClasses":         int64(500),
+	})
+
+	s := FromPerfData(pd)
+	if s.Uptime != 35*time.Second {
+		t.Errorf("Uptime = %v, want 35s", s.Uptime)
+	}
+	if s.GC.YGC != 12 || s.GC.YGCT != 250*time.Millisecond || s.GC.FGC != 1 || s.GC.GCT != time.Second {
+		t.Errorf("GC = %+v", s.GC)
+	}
+	if s.GC.CGC != -1 || s.GC.CCSC != -1 || s.GC.LastCause != "G1 Evacuation Pause" {
+		t.Errorf("GC = %+v", s.GC)
+	}
+	if s.Class.Loaded != 3500 || s.Compiler.Compiled != -1 {
+		t.Errorf("class %+v, compiler %+v", s.Class, s.Compiler)
+	}
+
+	u := s.GC.Util()
+	if u.E != 25 || u.S0 != 0 || u.S1 != 25 || u.O != 75 || u.M != 75 || u.CCS != -1 {
+		t.Errorf("Util() = %+v", u)
+	}
+}
+
+func TestViews(t *testing.T) {
+	// Only the timer is published, so every other counter is unknown
+	s := FromPerfData(perfData(t, map[string]any{
+		"sun.os.hrt.frequency": int64(1000),
+		"sun.os.hrt.ticks":     int64(1000),
+	}))
+	if s.Uptime != time.Second || s.GC.EC != -1 || s.GC.GCT != 0 || s.Class.Loaded != 0 {
+		t.Errorf("snapshot = %+v", s)
+	}
+
+	for _, name := range []string{"gc", "-gcutil", "class"} {
+		v, err := ParseView(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, row := v.Header(true), v.Row(s, true)
+		if len(strings.Fields(header)) != len(strings.Fields(row)) {
+			t.Errorf("%s: header %q does not match row %q", name, header, row)
+		}
+	}
+	if _, err := ParseView("bogus"); err == nil {
+		t.Error("ParseView(bogus) succeeded")
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jstat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// View selects the columns of a jstat table
+type View string
+
+const (
+	// ViewGCUtil shows space utilization and collection counts (-gcutil)
+	ViewGCUtil View = "gcutil"
+
+	// ViewGC shows space sizes and collection counts (-gc)
+	ViewGC View = "gc"
+
+	// ViewGCCause is ViewGCUtil plus the collection causes (-gccause)
+	ViewGCCause View = "gccause"
+
+	// ViewClass shows class loader statistics (-class)
+	ViewClass View = "class"
+
+	// ViewCompiler shows JIT compiler statistics (-compiler)
+	ViewCompiler View = "compiler"
+)
+
+// ParseView parses a jstat option name, with or without the leading dash
+func ParseView(name string) (View, error) {
+	v := View(strings.TrimPrefix(name, "-"))
+	if _, ok := viewColumns[v]; !ok {
+		return "", fmt.Errorf("unknown jstat option %q", name)
+	}
+	return v, nil
+}
+
+// column is one column of a jstat table
+type column struct {
+	header string
+	width  int // Negative for left alignment
+	value  func(s *Snapshot) string
+}
+
+var timestampColumn = column{"Timestamp", 10, func(s *Snapshot) string { return fixed(s.Uptime.Seconds(), 1) }}
+
+var gcutilColumns = []column{
+	{"S0", 6, func(s *Snapshot) string { return pct(s.GC.Util().S0) }},
+	{"S1", 6, func(s *Snapshot) string { return pct(s.GC.Util().S1) }},
+	{"E", 6, func(s *Snapshot) string { return pct(s.GC.Util().E) }},
+	{"O", 6, func(s *Snapshot) string { return pct(s.GC.Util().O) }},
+	{"M", 6, func(s *Snapshot) string { return pct(s.GC.Util().M) }},
+	{"CCS", 6, func(s *Snapshot) string { return pct(s.GC.Util().CCS) }},
+}
+
+var gcCountColumns = []column{
+	{"YGC", 7, func(s *Snapshot) string { return count(s.GC.YGC) }},
+	{"YGCT", 9, func(s *Snapshot) string { return fixed(s.GC.YGCT.Seconds(), 3) }},
+	{"FGC", 6, func(s *Snapshot) string { return count(s.GC.FGC) }},
+	{"FGCT", 9, func(s *Snapshot) string { return fixed(s.GC.FGCT.Seconds(), 3) }},
+	{"CGC", 6, func(s *Snapshot) string { return count(s.GC.CGC) }},
+	{"CGCT", 9, func(s *Snapshot) string {
+		if s.GC.CGC < 0 {
+			return "-"
+		}
+		return fixed(s.GC.CGCT.Seconds(), 3)
+	}},
+	{"GCT", 9, func(s *Snapshot) string { return fixed(s.GC.GCT.Seconds(), 3) }},
+}
+
+var gcSizeColumns = []column{
+	{"S0C", 10, func(s *Snapshot) string { return kb(s.GC.S0C) }},
+	{"S1C", 10, func(s *Snapshot) string { return kb(s.GC.S1C) }},
+	{"S0U", 10, func(s *Snapshot) string { return kb(s.GC.S0U) }},
+	{"S1U", 10, func(s *Snapshot) string { return kb(s.GC.S1U) }},
+	{"EC", 11, func(s *Snapshot) string { return kb(s.GC.EC) }},
+	{"EU", 11, func(s *Snapshot) string { return kb(s.GC.EU) }},
+	{"OC", 11, func(s *Snapshot) string { return kb(s.GC.OC) }},
+	{"OU", 11, func(s *Snapshot) string { return kb(s.GC.OU) }},
+	{"MC", 10, func(s *Snapshot) string { return kb(s.GC.MC) }},
+	{"MU", 10, func(s *Snapshot) string { return kb(s.GC.MU) }},
+	{"CCSC", 9, func(s *Snapshot) string { return kb(s.GC.CCSC) }},
+	{"CCSU", 9, func(s *Snapshot) string { return kb(s.GC.CCSU) }},
+}
+
+var causeColumns = []column{
+	{"LGCC", -24, func(s *Snapshot) string { return text(s.GC.LastCause) }},
+	{"GCC", -24, func(s *Snapshot) string { return text(s.GC.Cause) }},
+}
+
+var classColumns = []column{
+	{"Loaded", 8, func(s *Snapshot) string { return count(s.Class.Loaded) }},
+	{"Bytes", 10, func(s *Snapshot) string { return kb(s.Class.LoadedBytes) }},
+	{"Unloaded", 9, func(s *Snapshot) string { return count(s.Class.Unloaded) }},
+	{"Bytes", 10, func(s *Snapshot) string { return kb(s.Class.UnloadedBytes) }},
+	{"Time", 9, func(s *Snapshot) string { return fixed(s.Class.Time.Seconds(), 2) }},
+}
+
+var compilerColumns = []column{
+	{"Compiled", 9, func(s *Snapshot) string { return count(s.Compiler.Compiled) }},
+	{"Failed", 7, func(s *Snapshot) string { return count(s.Compiler.Failed) }},
+	{"Invalid", 8, func(s *Snapshot) string { return count(s.Compiler.Invalid) }},
+	{"Time", 9, func(s *Snapshot) string { return fixed(s.Compiler.Time.Seconds(), 2) }},
+	{"FailedType", 11, func(s *Snapshot) string { return count(s.Compiler.FailedType) }},
+	{"FailedMethod", -1, func(s *Snapshot) string { return s.Compiler.FailedMethod }},
+}
+
+var viewColumns = map[View][]column{
+	ViewGCUtil:   concat(gcutilColumns, gcCountColumns),
+	ViewGC:       concat(gcSizeColumns, gcCountColumns),
+	ViewGCCause:  concat(gcutilColumns, gcCountColumns, causeColumns),
+	ViewClass:    classColumns,
+	ViewCompiler: compilerColumns,
+}
+
+// Header returns the table header line of the view
+// With timestamp set, a leading Timestamp column is included
+func (v View) Header(timestamp bool) string {
+	return v.format(timestamp, func(c column) string { return c.header })
+}
+
+// Row formats a snapshot as a table row of the view
+func (v View) Row(s *Snapshot, timestamp bool) string {
+	return v.format(timestamp, func(c column) string { return c.value(s) })
+}
+
+func (v View) format(timestamp bool, cell func(c column) string) string {
+	columns := viewColumns[v]
+	if timestamp {
+		columns = concat([]column{timestampColumn}, columns)
+	}
+
+	var sb strings.Builder
+	for i, c := range columns {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if i == len(columns)-1 && c.width < 0 {
+			// No padding after the last column
+			sb.WriteString(cell(c))
+			continue
+		}
+		fmt.Fprintf(&sb, "%*s", c.width, cell(c))
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+func concat(groups ...[]column) []column {
+	var all []column
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+func pct(v float64) string {
+	if v < 0 {
+		return "-"
+	}
+	return fixed(v, 2)
+}
+
+func kb(v int64) string {
+	if v < 0 {
+		return "-"
+	}
+	return fixed(float64(v)/1024, 1)
+}
+
+func count(v int64) string {
+	if v < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func fixed(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+func text(s string) string {
+	if s == "" {
+		return "No GC"
+	}
+	return s
+}
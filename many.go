@@ -0,0 +1,134 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xxs-2/jattach-go/internal/process"
+)
+
+// AttachManyOptions configures AttachMany
+type AttachManyOptions struct {
+	// Workers is the number of targets attached to at the same time
+	// (default: 8)
+	Workers int
+
+	// Timeout bounds the whole attach to each target, on top of the
+	// per-phase timeouts of the client (default: no limit)
+	Timeout time.Duration
+
+	// FailFast stops at the first target that fails, either because the
+	// attach failed or because the JVM returned a non-zero code. Targets
+	// still running are cancelled and those not started yet are reported
+	// with a context.Canceled error. Without it every target is attempted
+	FailFast bool
+}
+
+// AttachMany sends the same command to every target, a bounded number at a
+// time, and streams one Result per target as each completes. The channel is
+// buffered for all targets and closed after the last result, so it never
+// blocks the workers
+//
+// Output is never printed, whatever Options.PrintOutput says. Targets owned
+// by another user than the caller are attached through a helper process
+// (see Options.Helper), since credentials are switched for the whole
+// process and could not differ between concurrent attaches otherwise
+func (c *Client) AttachMany(ctx context.Context, targets []int, cmd string, args []string, opts *AttachManyOptions) <-chan *Result {
+	if opts == nil {
+		opts = &AttachManyOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 8
+	}
+
+	local := *c.options
+	local.PrintOutput = false
+	helper := local
+	helper.Helper = true
+	clients := manyClients{
+		local:  NewClientWithOptions(&local),
+		helper: NewClientWithOptions(&helper),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan *Result, len(targets))
+	pids := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pid := range pids {
+				r := clients.attach(ctx, pid, cmd, args, opts.Timeout)
+				if opts.FailFast && (r.Err != nil || r.Response.Code != 0) {
+					cancel()
+				}
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		defer cancel()
+		for _, pid := range targets {
+			if ctx.Err() != nil {
+				results <- &Result{PID: pid, Command: cmd, Args: args, Err: wrapError("attach", pid, ctx.Err())}
+				continue
+			}
+			select {
+			case pids <- pid:
+			case <-ctx.Done():
+				results <- &Result{PID: pid, Command: cmd, Args: args, Err: wrapError("attach", pid, ctx.Err())}
+			}
+		}
+		close(pids)
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// manyClients are the clients of AttachMany for targets attached in process
+// and through a helper
+type manyClients struct {
+	local  *Client
+	helper *Client
+}
+
+// attach runs the command against one target of AttachMany
+func (m manyClients) attach(ctx context.Context, pid int, cmd string, args []string, timeout time.Duration) *Result {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	client := m.local
+	if client.options.Helper || needsHelper(client.options.ProcRoot, pid) {
+		client = m.helper
+	}
+
+	resp, err := client.AttachWithContext(ctx, pid, cmd, args...)
+	return &Result{PID: pid, Command: cmd, Args: args, Response: resp, Err: err}
+}
+
+// needsHelper reports whether attaching to pid switches the credentials of
+// the process. Processes that are gone are left to AttachWithContext to
+// report
+func needsHelper(procRoot string, pid int) bool {
+	info, err := process.GetProcessInfo(procRoot, pid)
+	if err != nil {
+		return false
+	}
+	return int(info.UID) != os.Geteuid() || int(info.GID) != os.Getegid()
+}
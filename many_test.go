@@ -0,0 +1,125 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+// newManyHotSpot starts a fake HotSpot JVM whose GC.heap_info reply takes
+// delay, with its attach listener already running. The fake JVMs all have
+// the PID of the test process, so the targets of AttachMany are that JVM
+// over and over
+func newManyHotSpot(t *testing.T, delay time.Duration) (*jattach.Client, *jattachtest.Server) {
+	t.Helper()
+	script := jattachtest.HotSpot17()
+	script["jcmd GC.heap_info"] = jattachtest.Reply{Output: "garbage-first heap\n", Delay: delay}
+	client, jvm := newHotSpot(t, script)
+	if _, err := client.Attach(jvm.PID(), "jcmd", "VM.version"); err != nil {
+		t.Fatal(err)
+	}
+	return client, jvm
+}
+
+// heapInfos returns the number of GC.heap_info commands received by jvm
+func heapInfos(jvm *jattachtest.Server) int {
+	n := 0
+	for _, cmd := range jvm.Commands() {
+		if cmd.Key() == "jcmd GC.heap_info" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAttachManyWorkers(t *testing.T) {
+	client, jvm := newManyHotSpot(t, 500*time.Millisecond)
+	targets := []int{jvm.PID(), jvm.PID(), jvm.PID(), jvm.PID(), jvm.PID()}
+
+	results := client.AttachMany(context.Background(), targets, "jcmd", []string{"GC.heap_info"},
+		&jattach.AttachManyOptions{Workers: 2})
+
+	// Once two commands are in flight, the other targets wait for them
+	deadline := time.Now().Add(5 * time.Second)
+	for heapInfos(jvm) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := heapInfos(jvm); n != 2 {
+		t.Errorf("%d commands in flight, want 2", n)
+	}
+
+	n := 0
+	for r := range results {
+		if r.Err != nil || !strings.Contains(r.Response.Output, "garbage-first heap") {
+			t.Errorf("result = %+v", r)
+		}
+		n++
+	}
+	if n != len(targets) || heapInfos(jvm) != len(targets) {
+		t.Errorf("%d results, %d commands, want %d", n, heapInfos(jvm), len(targets))
+	}
+}
+
+func TestAttachManyFailFast(t *testing.T) {
+	client, jvm := newManyHotSpot(t, 0)
+	targets := []int{jvm.PID(), -1, jvm.PID(), jvm.PID()}
+
+	results := client.AttachMany(context.Background(), targets, "jcmd", []string{"GC.heap_info"},
+		&jattach.AttachManyOptions{Workers: 1, FailFast: true})
+
+	var got []*jattach.Result
+	for r := range results {
+		got = append(got, r)
+	}
+	if len(got) != len(targets) {
+		t.Fatalf("%d results, want %d", len(got), len(targets))
+	}
+	if got[0].PID != jvm.PID() || got[0].Err != nil {
+		t.Errorf("first result = %+v", got[0])
+	}
+	if got[1].PID != -1 || !errors.Is(got[1].Err, jattach.ErrProcessNotFound) {
+		t.Errorf("failed result = %+v", got[1])
+	}
+	for _, r := range got[2:] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("result after the failure = %+v, want context.Canceled", r)
+		}
+	}
+	if n := heapInfos(jvm); n != 1 {
+		t.Errorf("%d commands sent, want 1", n)
+	}
+}
+
+func TestAttachManyTimeout(t *testing.T) {
+	client, jvm := newManyHotSpot(t, time.Minute)
+	targets := []int{jvm.PID(), jvm.PID(), jvm.PID()}
+
+	start := time.Now()
+	results := client.AttachMany(context.Background(), targets, "jcmd", []string{"GC.heap_info"},
+		&jattach.AttachManyOptions{Workers: 3, Timeout: 200 * time.Millisecond})
+
+	n := 0
+	for r := range results {
+		if !errors.Is(r.Err, jattach.ErrTimeout) {
+			t.Errorf("result = %+v, want ErrTimeout", r)
+		}
+		n++
+	}
+	if n != len(targets) {
+		t.Errorf("%d results, want %d", n, len(targets))
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("AttachMany took %v", elapsed)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/hsperf"
+	"github.com/xxs-2/jattach-go/jattachtest"
+	"github.com/xxs-2/jattach-go/jstat"
+)
+
+// foreignPID is the PID of the fake JVMs in the procfs of fakeProcfs. It
+// must not exist in the namespace of the test
+const foreignPID = 4194000
+
+// fakeProcfs builds the procfs of another PID namespace, as a host procfs
+// mounted in a container: the test process is listed as foreignPID with
+// nspid as its PID inside its own namespace, and self is another process
+func fakeProcfs(t *testing.T, nspid int) string {
+	t.Helper()
+	if err := syscall.Kill(foreignPID, 0); !errors.Is(err, syscall.ESRCH) {
+		t.Skipf("PID %d exists", foreignPID)
+	}
+
+	root := t.TempDir()
+	dir := filepath.Join(root, strconv.Itoa(foreignPID))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := os.Geteuid(), os.Getegid()
+	status := fmt.Sprintf("Name:\tjava\nUid:\t%d\t%d\t%d\t%d\nGid:\t%d\t%d\t%d\t%d\nNStgid:\t%d\t%d\n",
+		uid, uid, uid, uid, gid, gid, gid, gid, foreignPID, nspid)
+	writeFile(t, filepath.Join(dir, "status"), status)
+	writeFile(t, filepath.Join(dir, "stat"), procStat(12345))
+	if err := os.Symlink("1", filepath.Join(root, "self")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// procStat returns a /proc/<pid>/stat line with the given start time
+func procStat(startTime uint64) string {
+	return fmt.Sprintf("%d (java) S 1 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 30 0 %d 0 0\n", foreignPID, startTime)
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForeignProcRootOpenJ9(t *testing.T) {
+	jvm, err := jattachtest.NewOpenJ9(jattachtest.OpenJ9())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	client := jattach.NewClientWithOptions(&jattach.Options{
+		TmpPath:       jvm.TmpPath(),
+		ProcRoot:      fakeProcfs(t, jvm.PID()),
+		NamespaceMode: jattach.NamespaceNone,
+	})
+	resp, err := client.Attach(foreignPID, "jcmd", "VM.version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.JVMType != jattach.JVMTypeOpenJ9 || resp.NsPID != jvm.PID() {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestForeignProcRootHotSpotNotSignaled(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	// The listener is not running and would need SIGQUIT, which cannot
+	// reach a process of another PID namespace
+	procRoot := fakeProcfs(t, jvm.PID())
+	hsperf := filepath.Join(jvm.TmpPath(), "hsperfdata_test")
+	os.Mkdir(hsperf, 0755)
+	writeFile(t, filepath.Join(hsperf, strconv.Itoa(jvm.PID())), "")
+
+	client := jattach.NewClientWithOptions(&jattach.Options{
+		TmpPath:       jvm.TmpPath(),
+		ProcRoot:      procRoot,
+		NamespaceMode: jattach.NamespaceNone,
+		SignalHook:    jvm.Signal,
+	})
+	_, err = client.Attach(foreignPID, "jcmd", "VM.version")
+	if !errors.Is(err, jattach.ErrNamespaceRequired) {
+		t.Fatalf("got %v, want ErrNamespaceRequired", err)
+	}
+	if len(jvm.Commands()) != 0 {
+		t.Errorf("fake JVM received %v", jvm.Commands())
+	}
+}
+
+func TestForeignProcRootDiscovery(t *testing.T) {
+	jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	// The hsperfdata file of the fake JVM, in the /tmp of the foreign process
+	procRoot := fakeProcfs(t, jvm.PID())
+	paths, _ := filepath.Glob(filepath.Join(jvm.TmpPath(), "hsperfdata_*", strconv.Itoa(jvm.PID())))
+	if len(paths) != 1 {
+		t.Fatalf("hsperfdata files = %v", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsperfDir := filepath.Join(procRoot, strconv.Itoa(foreignPID), "root", "tmp", "hsperfdata_app")
+	if err := os.MkdirAll(hsperfDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(hsperfDir, strconv.Itoa(jvm.PID())), string(data))
+
+	client := jattach.NewClientWithOptions(&jattach.Options{ProcRoot: procRoot})
+	jvms, err := client.ListJVMs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jvms) != 1 || jvms[0].PID != foreignPID || jvms[0].NsPID != jvm.PID() ||
+		jvms[0].JVMType != jattach.JVMTypeHotSpot || jvms[0].MainClass != "Main" {
+		t.Errorf("ListJVMs() = %+v", jvms)
+	}
+
+	pd, err := hsperf.OpenAt(procRoot, foreignPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pd.JavaCommand() != "Main" {
+		t.Errorf("JavaCommand() = %q", pd.JavaCommand())
+	}
+
+	sampler := jstat.NewSampler(foreignPID, time.Millisecond)
+	sampler.ProcRoot = procRoot
+	sampler.Count = 1
+	n := 0
+	for range sampler.Start(context.Background()) {
+		n++
+	}
+	if n != 1 || sampler.Err() != nil {
+		t.Errorf("got %d samples, error %v", n, sampler.Err())
+	}
+}
+
+func TestAttachManyHelperForOtherUser(t *testing.T) {
+	jvm, err := jattachtest.NewOpenJ9(jattachtest.OpenJ9())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jvm.Close()
+
+	procRoot := fakeProcfs(t, jvm.PID())
+	client := jattach.NewClientWithOptions(&jattach.Options{
+		TmpPath:       jvm.TmpPath(),
+		ProcRoot:      procRoot,
+		NamespaceMode: jattach.NamespaceNone,
+		SignalHook:    jvm.Signal,
+	})
+	attach := func() *jattach.Result {
+		t.Helper()
+		var got []*jattach.Result
+		for r := range client.AttachMany(context.Background(), []int{foreignPID}, "jcmd", []string{"VM.version"}, nil) {
+			got = append(got, r)
+		}
+		if len(got) != 1 {
+			t.Fatalf("results = %+v", got)
+		}
+		return got[0]
+	}
+
+	// A target of the caller's user is attached in process
+	if r := attach(); r.Err != nil {
+		t.Fatalf("own target: %v", r.Err)
+	}
+
+	// Any other is sent to a helper, which a SignalHook rules out
+	uid, gid := os.Geteuid()+1, os.Getegid()
+	status := fmt.Sprintf("Name:\tjava\nUid:\t%d\t%d\t%d\t%d\nGid:\t%d\t%d\t%d\t%d\nNStgid:\t%d\t%d\n",
+		uid, uid, uid, uid, gid, gid, gid, gid, foreignPID, jvm.PID())
+	writeFile(t, filepath.Join(procRoot, strconv.Itoa(foreignPID), "status"), status)
+	var attachErr *jattach.AttachError
+	if r := attach(); !errors.As(r.Err, &attachErr) || attachErr.Op != "start_helper" {
+		t.Fatalf("target of another user: %v, want a helper", r.Err)
+	}
+	if n := len(jvm.Commands()); n != 1 {
+		t.Errorf("fake JVM received %d commands, want 1", n)
+	}
+}
+
+func TestCheckJVM(t *testing.T) {
+	const javaMaps = "7f0000000000-7f0000100000 r-xp 00000000 08:01 1234 /usr/lib/jvm/java-17/lib/server/libjvm.so\n"
+	tests := []struct {
+		name    string
+		exe     string
+		maps    string
+		sigCgt  string
+		cmdline string
+		want    error
+	}{
+		{"not java", "/usr/bin/python3", "7f0000000000-7f0000100000 r-xp 00000000 08:01 1234 /usr/lib/libc.so.6\n",
+			"", "python3\x00app.py\x00", jattach.ErrNotJavaProcess},
+		{"SIGQUIT not caught", "/usr/lib/jvm/java-17/bin/java", javaMaps,
+			"0000000181005cca", "java\x00Main\x00", jattach.ErrReduceSignalUsage},
+		{"-Xrs", "/usr/lib/jvm/java-17/bin/java", javaMaps,
+			"", "java\x00-Xmx1g\x00-Xrs\x00Main\x00", jattach.ErrReduceSignalUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The listener is not running, so SIGQUIT would be needed. The
+			// hsperfdata file is removed, leaving the fixture as the only
+			// evidence of a JVM
+			jvm, err := jattachtest.NewHotSpot(jattachtest.HotSpot17())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer jvm.Close()
+			hsperf, _ := filepath.Glob(filepath.Join(jvm.TmpPath(), "hsperfdata_*"))
+			for _, dir := range hsperf {
+				os.RemoveAll(dir)
+			}
+
+			procRoot := fakeProcfs(t, jvm.PID())
+			dir := filepath.Join(procRoot, strconv.Itoa(foreignPID))
+			if err := os.Symlink(tt.exe, filepath.Join(dir, "exe")); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, filepath.Join(dir, "maps"), tt.maps)
+			writeFile(t, filepath.Join(dir, "cmdline"), tt.cmdline)
+			if tt.sigCgt != "" {
+				status, err := os.ReadFile(filepath.Join(dir, "status"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(dir, "status"), string(status)+"SigCgt:\t"+tt.sigCgt+"\n")
+			}
+
+			signaled := false
+			client := jattach.NewClientWithOptions(&jattach.Options{
+				TmpPath:       jvm.TmpPath(),
+				ProcRoot:      procRoot,
+				NamespaceMode: jattach.NamespaceNone,
+				SignalHook: func(pid int, sig syscall.Signal) error {
+					signaled = true
+					return jvm.Signal(pid, sig)
+				},
+			})
+			_, err = client.Attach(foreignPID, "jcmd", "VM.version")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if signaled || len(jvm.Commands()) != 0 {
+				t.Errorf("fake JVM signaled (%v) or received %v", signaled, jvm.Commands())
+			}
+		})
+	}
+}
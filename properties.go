@@ -0,0 +1,35 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+
+	"github.com/xxs-2/jattach-go/internal/javaprops"
+)
+
+// SystemProperties returns the system properties of the JVM
+// HotSpot and OpenJ9 both reply in the java.util.Properties file format,
+// which is decoded here (\uXXXX escapes, line continuations, escaped
+// separators), so the result is the same whatever the JVM type
+func (c *Client) SystemProperties(ctx context.Context, pid int) (map[string]string, error) {
+	return c.properties(ctx, pid, CmdProperties)
+}
+
+// AgentProperties returns the agent properties of the JVM, such as
+// sun.jvm.args and the JMX connector address, decoded like
+// SystemProperties
+func (c *Client) AgentProperties(ctx context.Context, pid int) (map[string]string, error) {
+	return c.properties(ctx, pid, CmdAgentProperties)
+}
+
+func (c *Client) properties(ctx context.Context, pid int, cmd string) (map[string]string, error) {
+	output, _, err := c.command(ctx, pid, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return javaprops.Parse(output), nil
+}
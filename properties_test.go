@@ -0,0 +1,43 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+func TestProperties(t *testing.T) {
+	ctx := context.Background()
+	hotspot, hsJVM := newHotSpot(t, jattachtest.HotSpot21())
+	openj9, j9JVM := newOpenJ9(t, jattachtest.OpenJ9())
+
+	props, err := hotspot.SystemProperties(ctx, hsJVM.PID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props["java.version"] != "21.0.3" || props["line.separator"] != "\n" || props["path.separator"] != ":" {
+		t.Errorf("HotSpot properties = %v", props)
+	}
+
+	props, err = openj9.SystemProperties(ctx, j9JVM.PID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props["java.vm.name"] != "Eclipse OpenJ9 VM" || props["line.separator"] != "\n" {
+		t.Errorf("OpenJ9 properties = %v", props)
+	}
+
+	props, err = openj9.AgentProperties(ctx, j9JVM.PID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props["sun.jvm.args"] != "-Xmx1g -XX:+HeapDumpOnOutOfMemoryError" {
+		t.Errorf("OpenJ9 agent properties = %v", props)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xxs-2/jattach-go/internal/protocol"
+)
+
+// Session runs several commands against one JVM
+// On OpenJ9 it keeps the attach connection open, so the handshake (global
+// attach lock, replyInfo file, semaphore notification, accept and key
+// check) is done once for all commands instead of once per command. HotSpot
+// serves a single command per connection, so a HotSpot session attaches for
+// each command, which is cheap once the attach listener is running
+// In helper mode every command attaches on its own through the helper
+type Session struct {
+	client *Client
+	pid    int
+	nsPID  int
+
+	// conn is the open OpenJ9 connection, nil when each command attaches
+	conn *protocol.OpenJ9Session
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// OpenSession attaches to the JVM for a series of commands sent with Do
+// The session must be closed, which detaches from an OpenJ9 JVM
+func (c *Client) OpenSession(ctx context.Context, pid int) (*Session, error) {
+	s := &Session{client: c, pid: pid}
+	if c.options.Helper {
+		return s, nil
+	}
+
+	err := c.withTarget(pid, func(t *target) error {
+		s.nsPID = t.info.NsPID
+		if t.jvmType != JVMTypeOpenJ9 {
+			return nil
+		}
+
+		conn, err := protocol.ConnectOpenJ9(ctx, t.protocolTarget(), c.protocolOptions(c.options.PrintOutput))
+		if err != nil {
+			return attachError(pid, err)
+		}
+		s.conn = conn
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Do sends a command over the session
+func (s *Session) Do(cmd string, args ...string) (*Response, error) {
+	return s.DoContext(context.Background(), cmd, args...)
+}
+
+// DoContext sends a command over the session, allowing cancellation via
+// context. An OpenJ9 session cannot be used again after a failed command,
+// since the connection may still hold part of the response
+func (s *Session) DoContext(ctx context.Context, cmd string, args ...string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, wrapError(cmd, s.pid, ErrSessionClosed)
+	}
+	if s.conn == nil {
+		return s.client.AttachWithContext(ctx, s.pid, cmd, args...)
+	}
+
+	var timings []protocol.Timing
+	opts := s.client.protocolOptions(s.client.options.PrintOutput)
+	opts.Timings = &timings
+
+	protoResp, err := s.conn.Do(ctx, cmd, args, opts)
+	if err != nil {
+		s.closed = true
+		return nil, attachError(s.pid, err)
+	}
+
+	return &Response{
+		Code:    protoResp.Code,
+		Output:  protoResp.Output,
+		JVMType: JVMTypeOpenJ9,
+		PID:     s.pid,
+		NsPID:   s.nsPID,
+		Command: cmd,
+		Args:    args,
+		Timings: convertTimings(timings),
+	}, nil
+}
+
+// Close ends the session, detaching from an OpenJ9 JVM. It is safe to call
+// more than once
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	if err := s.conn.Close(context.Background(), s.client.protocolOptions(false)); err != nil {
+		return wrapError("detach", s.pid, err)
+	}
+	return nil
+}
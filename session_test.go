@@ -0,0 +1,60 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package jattach_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/xxs-2/jattach-go"
+	"github.com/xxs-2/jattach-go/jattachtest"
+)
+
+func TestSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  func(t *testing.T, script jattachtest.Script) (*jattach.Client, *jattachtest.Server)
+		script jattachtest.Script
+
+		// connections is the number of attach connections for the two
+		// commands: HotSpot serves one command per connection, an OpenJ9
+		// session keeps its connection
+		connections int
+	}{
+		{"HotSpot", newHotSpot, jattachtest.HotSpot17(), 2},
+		{"OpenJ9", newOpenJ9, jattachtest.OpenJ9(), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, jvm := tt.start(t, tt.script)
+
+			session, err := client.OpenSession(context.Background(), jvm.PID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, cmd := range []string{"properties", "agentProperties"} {
+				if _, err := session.Do(cmd); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := session.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := session.Do("properties"); !errors.Is(err, jattach.ErrSessionClosed) {
+				t.Errorf("Do() after Close = %v, want ErrSessionClosed", err)
+			}
+			if got := commandKeys(jvm); got != "properties, agentProperties" {
+				t.Errorf("commands = %s", got)
+			}
+			if n := jvm.Connections(); n != tt.connections {
+				t.Errorf("%d connections, want %d", n, tt.connections)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package threaddump
+
+import (
+	"sort"
+	"strings"
+)
+
+// LockGraph is the wait-for graph of a thread dump: which thread holds each
+// monitor or synchronizer, and which threads are blocked on it
+// It is built from the stacks themselves, so it also covers ReentrantLock
+// and other j.u.c chains that the JVM's own deadlock report can miss, and
+// it works the same for HotSpot and OpenJ9 dumps
+type LockGraph struct {
+	// Locks maps a lock ID to its node
+	Locks map[string]*LockNode
+
+	// waitsFor maps a blocked thread to the lock it is blocked on
+	waitsFor map[*Thread]*LockNode
+
+	threads []*Thread
+}
+
+// LockNode is a lock with its owner and the threads blocked on it
+type LockNode struct {
+	Lock Lock
+
+	// Owner is the thread holding the lock, nil if the dump does not show it
+	Owner *Thread
+
+	// Blocked are the threads entering, re-entering or parked on the lock
+	Blocked []*Thread
+}
+
+// Deadlock is a cycle in the wait-for graph
+// Threads[i] is blocked on Locks[i], which is held by Threads[i+1]; the
+// last lock is held by Threads[0]
+type Deadlock struct {
+	Threads []*Thread
+	Locks   []Lock
+}
+
+// Contention is a lock with blocked threads
+type Contention struct {
+	Lock  Lock
+	Owner *Thread
+
+	// Blocked is the number of blocked threads
+	Blocked int
+
+	// Frames groups the blocked threads by where they block, most
+	// crowded first
+	Frames []FrameGroup
+}
+
+// FrameGroup is a set of threads sharing the same top frame
+type FrameGroup struct {
+	// Frame is the top frame, outside of the JDK's locking machinery
+	Frame   string
+	Threads []*Thread
+}
+
+// LockGraph builds the wait-for graph of the dump
+func (d *ThreadDump) LockGraph() *LockGraph {
+	g := &LockGraph{
+		Locks:    make(map[string]*LockNode),
+		waitsFor: make(map[*Thread]*LockNode),
+		threads:  d.Threads,
+	}
+
+	for _, t := range d.Threads {
+		for _, lock := range t.Held() {
+			if lock.ID == "" {
+				continue
+			}
+			n := g.node(lock)
+			if n.Owner == nil {
+				n.Owner = t
+			}
+		}
+	}
+
+	for _, t := range d.Threads {
+		lock, _, ok := t.BlockedOn()
+		if !ok {
+			continue
+		}
+		n := g.node(lock)
+		n.Blocked = append(n.Blocked, t)
+		g.waitsFor[t] = n
+
+		// The ThreadInfo format names the owner in the header
+		if n.Owner == nil && (t.LockOwnerID != 0 || t.LockOwner != "") {
+			n.Owner = d.findOwner(t.LockOwnerID, t.LockOwner)
+		}
+	}
+
+	return g
+}
+
+func (g *LockGraph) node(lock Lock) *LockNode {
+	n, ok := g.Locks[lock.ID]
+	if !ok {
+		n = &LockNode{Lock: lock}
+		g.Locks[lock.ID] = n
+	} else if n.Lock.Class == "" {
+		n.Lock.Class = lock.Class
+	}
+	return n
+}
+
+func (d *ThreadDump) findOwner(id int64, name string) *Thread {
+	for _, t := range d.Threads {
+		if id != 0 && t.ID == id || id == 0 && t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// WaitsFor returns the lock the thread is blocked on and its owner
+func (g *LockGraph) WaitsFor(t *Thread) (*LockNode, bool) {
+	n, ok := g.waitsFor[t]
+	return n, ok
+}
+
+// Deadlocks returns the cycles of the graph, in dump order of their first
+// thread
+func (g *LockGraph) Deadlocks() []Deadlock {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[*Thread]int)
+	var deadlocks []Deadlock
+
+	for _, start := range g.threads {
+		if state[start] != unvisited {
+			continue
+		}
+
+		// Each thread waits for at most one lock, so the path is a chain
+		var path []*Thread
+		t := start
+		for t != nil && state[t] == unvisited {
+			state[t] = visiting
+			path = append(path, t)
+			n := g.waitsFor[t]
+			if n == nil {
+				t = nil
+				break
+			}
+			t = n.Owner
+		}
+
+		// Reaching a thread of the current path closes a cycle
+		if t != nil && state[t] == visiting {
+			idx := 0
+			for path[idx] != t {
+				idx++
+			}
+			deadlocks = append(deadlocks, g.deadlock(path[idx:]))
+		}
+
+		for _, p := range path {
+			state[p] = done
+		}
+	}
+
+	return deadlocks
+}
+
+// deadlock builds a Deadlock from a cycle, starting at its first thread in
+// dump order
+func (g *LockGraph) deadlock(cycle []*Thread) Deadlock {
+	order := make(map[*Thread]int)
+	for i, t := range g.threads {
+		order[t] = i
+	}
+	first := 0
+	for i, t := range cycle {
+		if order[t] < order[cycle[first]] {
+			first = i
+		}
+	}
+
+	d := Deadlock{}
+	for i := range cycle {
+		t := cycle[(first+i)%len(cycle)]
+		d.Threads = append(d.Threads, t)
+		d.Locks = append(d.Locks, g.waitsFor[t].Lock)
+	}
+	return d
+}
+
+// Contended returns the locks with blocked threads, most blocked first
+func (g *LockGraph) Contended() []Contention {
+	var result []Contention
+	for _, n := range g.Locks {
+		if len(n.Blocked) == 0 {
+			continue
+		}
+		result = append(result, Contention{
+			Lock:    n.Lock,
+			Owner:   n.Owner,
+			Blocked: len(n.Blocked),
+			Frames:  groupByFrame(n.Blocked),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Blocked != result[j].Blocked {
+			return result[i].Blocked > result[j].Blocked
+		}
+		return result[i].Lock.ID < result[j].Lock.ID
+	})
+	return result
+}
+
+func groupByFrame(threads []*Thread) []FrameGroup {
+	var groups []FrameGroup
+	index := make(map[string]int)
+
+	for _, t := range threads {
+		frame := blockingFrame(t)
+		i, ok := index[frame]
+		if !ok {
+			i = len(groups)
+			index[frame] = i
+			groups = append(groups, FrameGroup{Frame: frame})
+		}
+		groups[i].Threads = append(groups[i].Threads, t)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Threads) > len(groups[j].Threads)
+	})
+	return groups
+}
+
+// lockingPackages are skipped when choosing the frame a thread blocks in,
+// so that parked threads are told apart by the code that took the lock
+var lockingPackages = []string{
+	"sun.misc.Unsafe.",
+	"jdk.internal.misc.Unsafe.",
+	"java.util.concurrent.locks.",
+}
+
+// blockingFrame returns the top frame of the thread outside of the JDK's
+// locking machinery
+func blockingFrame(t *Thread) string {
+	for _, f := range t.Frames {
+		skip := false
+		for _, pkg := range lockingPackages {
+			if strings.HasPrefix(f.Method, pkg) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			return f.String()
+		}
+	}
+	if top := t.TopFrame(); top != nil {
+		return top.String()
+	}
+	return ""
+}
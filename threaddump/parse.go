@@ -0,0 +1,411 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package threaddump
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xxs-2/jattach-go/internal/javaprops"
+)
+
+// Parse reads a thread dump. It understands the HotSpot format of JDK 8
+// through JDK 21+, including the return code line of a raw attach response
+// and the "Carrying virtual thread" lines of JDK 21 carrier threads, and the
+// ThreadInfo format printed by OpenJ9's Thread.print, either as text or as
+// the raw diagnostics reply
+func Parse(r io.Reader) (*ThreadDump, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseString(string(data))
+}
+
+// ParseString parses a thread dump held in a string
+func ParseString(s string) (*ThreadDump, error) {
+	p := &parser{dump: &ThreadDump{}}
+
+	scanner := bufio.NewScanner(strings.NewReader(javaprops.DiagnosticsResult(s)))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		p.line(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.dump.Threads == nil {
+		p.dump.Threads = []*Thread{}
+	}
+	return p.dump, nil
+}
+
+// parser holds the state between lines
+type parser struct {
+	dump *ThreadDump
+
+	// thread is the thread whose details are being read, if any
+	thread *Thread
+
+	// synchronizers is set inside a "Locked ownable synchronizers" list
+	synchronizers bool
+
+	// deadlocks is set inside the VM's own deadlock report
+	deadlocks bool
+}
+
+var (
+	timestampLine = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}$`)
+	deadlockLine  = regexp.MustCompile(`^Found (\w+) Java-level deadlocks?:`)
+	virtualHeader = regexp.MustCompile(`^#(\d+) "(.*)" virtual\b`)
+)
+
+func (p *parser) line(line string) {
+	trimmed := strings.TrimSpace(line)
+
+	switch {
+	case p.deadlocks:
+		// The deadlock report runs to the end of the dump, except when
+		// several dumps are concatenated
+		if strings.HasPrefix(line, "Full thread dump") {
+			p.deadlocks = false
+			p.line(line)
+		}
+		return
+
+	case timestampLine.MatchString(trimmed):
+		p.dump.Timestamp = trimmed
+		p.endThread()
+
+	case strings.HasPrefix(trimmed, "Full thread dump "):
+		p.dump.VM = strings.TrimSuffix(strings.TrimPrefix(trimmed, "Full thread dump "), ":")
+		p.endThread()
+
+	case deadlockLine.MatchString(trimmed):
+		m := deadlockLine.FindStringSubmatch(trimmed)
+		if m[1] == "one" {
+			p.dump.ReportedDeadlocks++
+		} else if n, err := strconv.Atoi(m[1]); err == nil {
+			p.dump.ReportedDeadlocks += n
+		}
+		p.endThread()
+		p.deadlocks = true
+
+	case strings.HasPrefix(trimmed, "JRE ") && p.dump.VM == "" && len(p.dump.Threads) == 0:
+		// OpenJ9 starts Thread.print with java.vm.info
+		p.dump.VM = trimmed
+
+	case strings.HasPrefix(line, "\""):
+		p.startThread(parseHeader(line))
+
+	case virtualHeader.MatchString(line):
+		m := virtualHeader.FindStringSubmatch(line)
+		id, _ := strconv.ParseInt(m[1], 10, 64)
+		p.startThread(&Thread{Name: m[2], ID: id, Virtual: true})
+
+	case p.thread == nil:
+		// Preamble, JNI references and other text between threads
+
+	case trimmed == "":
+		p.synchronizers = false
+
+	case line[0] != ' ' && line[0] != '\t':
+		// Unindented text ends the thread
+		p.endThread()
+
+	default:
+		p.detail(trimmed)
+	}
+}
+
+func (p *parser) startThread(t *Thread) {
+	p.dump.Threads = append(p.dump.Threads, t)
+	p.thread = t
+	p.synchronizers = false
+}
+
+func (p *parser) endThread() {
+	p.thread = nil
+	p.synchronizers = false
+}
+
+// detail parses an indented line belonging to the current thread
+func (p *parser) detail(line string) {
+	t := p.thread
+
+	switch {
+	case strings.HasPrefix(line, "java.lang.Thread.State: "):
+		state := strings.TrimPrefix(line, "java.lang.Thread.State: ")
+		if idx := strings.Index(state, " ("); idx != -1 {
+			t.StateDetail = strings.TrimSuffix(state[idx+2:], ")")
+			state = state[:idx]
+		}
+		t.State = state
+
+	case strings.HasPrefix(line, "Carrying virtual thread #"):
+		t.CarriedThreadID, _ = strconv.ParseInt(strings.TrimPrefix(line, "Carrying virtual thread #"), 10, 64)
+
+	case strings.HasPrefix(line, "Locked ownable synchronizers:"),
+		strings.HasPrefix(line, "Number of locked synchronizers"),
+		strings.HasPrefix(line, "Locked synchronizers"):
+		p.synchronizers = true
+
+	case strings.HasPrefix(line, "at "):
+		t.Frames = append(t.Frames, parseFrame(strings.TrimPrefix(line, "at ")))
+
+	case p.synchronizers:
+		if lock, ok := parseLock(strings.TrimSpace(strings.TrimPrefix(line, "- "))); ok {
+			t.Synchronizers = append(t.Synchronizers, lock)
+		}
+
+	case strings.HasPrefix(line, "- ") && len(t.Frames) > 0:
+		if ev, ok := parseLockEvent(strings.TrimSpace(line[2:])); ok {
+			f := t.Frames[len(t.Frames)-1]
+			// ThreadInfo reports parking as waiting on the synchronizer
+			if ev.Action == WaitingOn && isPark(f.Method) {
+				ev.Action = Parking
+			}
+			f.Locks = append(f.Locks, ev)
+		}
+
+	case t.Virtual && strings.Contains(line, "("):
+		// Thread.dump_to_file lists frames without the "at " prefix
+		t.Frames = append(t.Frames, parseFrame(line))
+	}
+}
+
+// parseHeader parses the first line of a thread
+// "name" #1 [123] daemon prio=5 os_prio=0 cpu=1.00ms elapsed=2.00s tid=0x... nid=0x7b waiting on condition  [0x...]
+func parseHeader(line string) *Thread {
+	name, rest := splitName(line)
+	t := &Thread{Name: name}
+
+	fields := strings.Fields(rest)
+	i := 0
+attributes:
+	for ; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case strings.HasPrefix(f, "#"):
+			t.ID, _ = strconv.ParseInt(f[1:], 10, 64)
+		case isNativeID(f):
+			t.NID, _ = strconv.ParseInt(f[1:len(f)-1], 10, 64)
+		case f == "daemon":
+			t.Daemon = true
+		case f == "virtual":
+			t.Virtual = true
+		case strings.Contains(f, "="):
+			t.attribute(f)
+		default:
+			break attributes
+		}
+	}
+
+	if i < len(fields) {
+		desc := fields[i:]
+		// The last field is the stack pointer of the last Java frame
+		if last := desc[len(desc)-1]; strings.HasPrefix(last, "[0x") {
+			desc = desc[:len(desc)-1]
+		}
+		t.Description = strings.Join(desc, " ")
+	}
+
+	if m := threadInfoState.FindStringSubmatch(t.Description); m != nil {
+		t.threadInfoState(m)
+	}
+
+	return t
+}
+
+// threadInfoState matches the state part of a ThreadInfo header
+// BLOCKED on java.lang.Object@1b6d3586 owned by "Thread-1" Id=15 (in native)
+var threadInfoState = regexp.MustCompile(`^(NEW|RUNNABLE|BLOCKED|WAITING|TIMED_WAITING|TERMINATED)` +
+	`(?: on (\S+))?(?: owned by "(.*)" Id=(\d+))?((?: \([a-z ]+\))*)$`)
+
+func (t *Thread) threadInfoState(m []string) {
+	t.State = m[1]
+	t.Description = ""
+	if lock, ok := parseLock(m[2]); ok {
+		t.Lock = &lock
+	}
+	t.LockOwner = m[3]
+	t.LockOwnerID, _ = strconv.ParseInt(m[4], 10, 64)
+	if detail := strings.TrimSpace(m[5]); detail != "" {
+		t.StateDetail = strings.Trim(detail, "()")
+	}
+}
+
+// splitName separates the quoted thread name from the attributes
+// Names may contain quotes, so the closing quote is the first one followed
+// by an attribute or the end of the line
+func splitName(line string) (string, string) {
+	s := line[1:]
+	for i := 0; i < len(s); i++ {
+		if s[i] != '"' {
+			continue
+		}
+		rest := s[i+1:]
+		if rest == "" || strings.TrimSpace(rest) == "" {
+			return s[:i], ""
+		}
+		if rest[0] != ' ' {
+			continue
+		}
+		next := strings.Fields(rest)[0]
+		if strings.HasPrefix(next, "#") || strings.Contains(next, "=") || next == "daemon" || next == "virtual" ||
+			isNativeID(next) || strings.HasPrefix(next, "Id=") {
+			return s[:i], rest
+		}
+	}
+	// No attributes: take everything up to the last quote
+	if idx := strings.LastIndexByte(s, '"'); idx != -1 {
+		return s[:idx], s[idx+1:]
+	}
+	return s, ""
+}
+
+// isNativeID matches the "[1234]" OS thread ID of JDK 19+ headers
+func isNativeID(f string) bool {
+	if len(f) < 3 || f[0] != '[' || f[len(f)-1] != ']' {
+		return false
+	}
+	_, err := strconv.ParseInt(f[1:len(f)-1], 10, 64)
+	return err == nil
+}
+
+// attribute parses one key=value field of a header
+func (t *Thread) attribute(f string) {
+	idx := strings.IndexByte(f, '=')
+	key, value := f[:idx], f[idx+1:]
+
+	switch key {
+	case "prio":
+		t.Priority, _ = strconv.Atoi(value)
+	case "os_prio":
+		t.OSPriority, _ = strconv.Atoi(value)
+	case "cpu":
+		t.CPUMillis, _ = strconv.ParseFloat(strings.TrimSuffix(value, "ms"), 64)
+	case "elapsed":
+		t.ElapsedSeconds, _ = strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64)
+	case "tid":
+		t.TID = value
+	case "nid":
+		t.NID = parseInt(value)
+	case "Id":
+		t.ID, _ = strconv.ParseInt(value, 10, 64)
+	}
+}
+
+// parseInt parses a decimal or 0x-prefixed hexadecimal number
+func parseInt(s string) int64 {
+	if strings.HasPrefix(s, "0x") {
+		n, _ := strconv.ParseInt(s[2:], 16, 64)
+		return n
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// parseFrame parses a stack frame without the "at " prefix
+// java.lang.Thread.sleep(java.base@17.0.2/Native Method)
+// java.base@11/java.lang.Thread.sleep(Native Method)
+func parseFrame(s string) *Frame {
+	f := &Frame{Method: s}
+
+	open := strings.IndexByte(s, '(')
+	if open == -1 {
+		return f
+	}
+	f.Method = s[:open]
+	f.Source = strings.TrimSuffix(s[open+1:], ")")
+
+	// JDK 9+ prefixes the source with the module
+	if idx := strings.IndexByte(f.Source, '/'); idx != -1 && !strings.ContainsAny(f.Source[:idx], ": ") {
+		f.Module = f.Source[:idx]
+		f.Source = f.Source[idx+1:]
+	}
+
+	// StackTraceElement.toString puts the class loader and module first,
+	// "app//Main.main" or "java.base@17/java.lang.Thread.sleep". A slash
+	// followed by 0x belongs to the name of a hidden class instead
+	for {
+		idx := strings.IndexByte(f.Method, '/')
+		if idx == -1 || strings.HasPrefix(f.Method[idx+1:], "0x") {
+			break
+		}
+		prefix := f.Method[:idx]
+		f.Method = f.Method[idx+1:]
+		if strings.HasPrefix(f.Method, "/") {
+			// Class loader without a module
+			f.Method = f.Method[1:]
+			continue
+		}
+		f.Module = prefix
+	}
+
+	return f
+}
+
+// isPark reports whether a method parks the thread for a j.u.c synchronizer
+func isPark(method string) bool {
+	return strings.HasSuffix(method, "Unsafe.park") || strings.HasSuffix(method, "LockSupport.park")
+}
+
+// lockActions maps the text of a lock line to its action
+var lockActions = []struct {
+	prefix string
+	action LockAction
+}{
+	{"locked", Locked},
+	{"waiting to lock", WaitingToLock},
+	{"waiting to re-lock in wait()", WaitingToRelock},
+	{"waiting on", WaitingOn},
+	{"parking to wait for", Parking},
+	{"eliminated", Eliminated},
+	{"blocked on", WaitingToLock},
+}
+
+// parseLockEvent parses a lock line of a frame without the "- " prefix
+func parseLockEvent(s string) (LockEvent, bool) {
+	for _, la := range lockActions {
+		if strings.HasPrefix(s, la.prefix) {
+			lock, _ := parseLock(strings.TrimSpace(s[len(la.prefix):]))
+			return LockEvent{Action: la.action, Lock: lock}, true
+		}
+	}
+	return LockEvent{}, false
+}
+
+// parseLock parses a lock object reference
+// <0x000000076ab62208> (a java.lang.Object)
+// java.lang.Object@1b6d3586
+func parseLock(s string) (Lock, bool) {
+	if strings.HasPrefix(s, "<") {
+		end := strings.IndexByte(s, '>')
+		if end == -1 {
+			return Lock{}, false
+		}
+		lock := Lock{}
+		if id := s[1:end]; strings.HasPrefix(id, "0x") {
+			lock.ID = id
+		}
+		rest := strings.TrimSpace(s[end+1:])
+		if strings.HasPrefix(rest, "(a ") {
+			lock.Class = strings.TrimSuffix(rest[3:], ")")
+		}
+		return lock, true
+	}
+
+	if idx := strings.LastIndexByte(s, '@'); idx > 0 && !strings.ContainsAny(s, " ") {
+		return Lock{ID: s[idx+1:], Class: s[:idx]}, true
+	}
+
+	return Lock{}, false
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package threaddump parses the text thread dumps printed by the JVM for the
+// threaddump command and jcmd Thread.print into typed values
+package threaddump
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// ThreadDump is a parsed thread dump
+type ThreadDump struct {
+	// Timestamp is the time line printed before the dump, if any
+	Timestamp string `json:"timestamp,omitempty"`
+
+	// VM is the "Full thread dump ..." banner without the prefix
+	VM string `json:"vm,omitempty"`
+
+	// Threads in the order they were printed
+	Threads []*Thread `json:"threads"`
+
+	// ReportedDeadlocks is the number of deadlocks the JVM itself found
+	ReportedDeadlocks int `json:"reported_deadlocks,omitempty"`
+}
+
+// Thread is one thread of a dump
+type Thread struct {
+	// Name of the thread, empty for unnamed virtual threads
+	Name string `json:"name"`
+
+	// ID is the Java thread ID ("#1" or "Id=1"), zero for VM threads
+	ID int64 `json:"id,omitempty"`
+
+	Daemon     bool `json:"daemon,omitempty"`
+	Priority   int  `json:"priority,omitempty"`
+	OSPriority int  `json:"os_priority,omitempty"`
+
+	// CPUMillis and ElapsedSeconds are printed by JDK 11 and later
+	CPUMillis      float64 `json:"cpu_ms,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_s,omitempty"`
+
+	// TID is the address of the VM thread structure
+	TID string `json:"tid,omitempty"`
+
+	// NID is the native (OS) thread ID
+	NID int64 `json:"nid,omitempty"`
+
+	// Description is the VM's summary, e.g. "waiting on condition"
+	Description string `json:"description,omitempty"`
+
+	// State is the java.lang.Thread.State, e.g. "TIMED_WAITING"
+	State string `json:"state,omitempty"`
+
+	// StateDetail qualifies the state, e.g. "sleeping" or "parking"
+	StateDetail string `json:"state_detail,omitempty"`
+
+	// Virtual is set for virtual threads
+	Virtual bool `json:"virtual,omitempty"`
+
+	// CarriedThreadID is the ID of the virtual thread this carrier thread
+	// is running, if any
+	CarriedThreadID int64 `json:"carried_thread_id,omitempty"`
+
+	// Lock is the lock the thread is blocked or waiting on, and LockOwner
+	// and LockOwnerID name its owner, when the header reports them
+	// (ThreadInfo format)
+	Lock        *Lock  `json:"lock,omitempty"`
+	LockOwner   string `json:"lock_owner,omitempty"`
+	LockOwnerID int64  `json:"lock_owner_id,omitempty"`
+
+	// Frames of the stack, innermost first
+	Frames []*Frame `json:"frames,omitempty"`
+
+	// Synchronizers are the ownable synchronizers (j.u.c locks) held
+	Synchronizers []Lock `json:"synchronizers,omitempty"`
+}
+
+// Frame is one stack frame
+type Frame struct {
+	// Method is the fully qualified method, e.g. "java.lang.Thread.sleep"
+	Method string `json:"method"`
+
+	// Module is the module and version, e.g. "java.base@17.0.2"
+	Module string `json:"module,omitempty"`
+
+	// Source is the file and line or "Native Method"
+	Source string `json:"source,omitempty"`
+
+	// Locks lists the lock operations printed under the frame
+	Locks []LockEvent `json:"locks,omitempty"`
+}
+
+// Lock identifies a monitor or synchronizer object
+type Lock struct {
+	// ID is the object address (HotSpot) or identity hash (ThreadInfo
+	// format). It is empty if the VM could not tell
+	ID string `json:"id,omitempty"`
+
+	// Class is the class of the lock object
+	Class string `json:"class,omitempty"`
+}
+
+// LockAction is what a thread does with a lock in a given frame
+type LockAction string
+
+const (
+	// Locked means the frame holds the monitor
+	Locked LockAction = "locked"
+
+	// WaitingToLock means the thread is blocked entering the monitor
+	WaitingToLock LockAction = "waiting_to_lock"
+
+	// WaitingToRelock means the thread was notified in Object.wait and
+	// is blocked re-entering the monitor
+	WaitingToRelock LockAction = "waiting_to_relock"
+
+	// WaitingOn means the thread is in Object.wait on the monitor
+	WaitingOn LockAction = "waiting_on"
+
+	// Parking means the thread is parked waiting for a j.u.c synchronizer
+	Parking LockAction = "parking"
+
+	// Eliminated means the lock was removed by escape analysis
+	Eliminated LockAction = "eliminated"
+)
+
+// LockEvent is a lock operation printed under a frame
+type LockEvent struct {
+	Action LockAction `json:"action"`
+	Lock   Lock       `json:"lock"`
+}
+
+// WriteJSON encodes the thread dump as indented JSON
+func (d *ThreadDump) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// Find returns the first thread with the given name, or nil
+func (d *ThreadDump) Find(name string) *Thread {
+	for _, t := range d.Threads {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// TopFrame returns the innermost frame, or nil for threads without a stack
+func (t *Thread) TopFrame() *Frame {
+	if len(t.Frames) == 0 {
+		return nil
+	}
+	return t.Frames[0]
+}
+
+// String formats the frame as in the HotSpot format, without "at "
+func (f *Frame) String() string {
+	if f.Source == "" && f.Module == "" {
+		return f.Method
+	}
+	if f.Module == "" {
+		return f.Method + "(" + f.Source + ")"
+	}
+	return f.Method + "(" + f.Module + "/" + f.Source + ")"
+}
+
+// Held returns the monitors and synchronizers held by the thread
+// A monitor released by Object.wait is still listed as locked by the frame
+// that entered it, so it is left out
+func (t *Thread) Held() []Lock {
+	released := make(map[string]bool)
+	for _, f := range t.Frames {
+		for _, ev := range f.Locks {
+			if ev.Action == WaitingOn || ev.Action == WaitingToRelock {
+				released[ev.Lock.ID] = true
+			}
+		}
+	}
+
+	var held []Lock
+	for _, f := range t.Frames {
+		for _, ev := range f.Locks {
+			if ev.Action == Locked && ev.Lock.ID != "" && !released[ev.Lock.ID] {
+				held = append(held, ev.Lock)
+			}
+		}
+	}
+	return append(held, t.Synchronizers...)
+}
+
+// BlockedOn returns the lock the thread is waiting to acquire, either a
+// monitor it is entering or a synchronizer it is parked on
+func (t *Thread) BlockedOn() (Lock, LockAction, bool) {
+	for _, f := range t.Frames {
+		for _, ev := range f.Locks {
+			switch ev.Action {
+			case WaitingToLock, WaitingToRelock, Parking:
+				if ev.Lock.ID != "" {
+					return ev.Lock, ev.Action, true
+				}
+			}
+		}
+	}
+
+	// Fall back to the lock in the header
+	if t.Lock != nil && t.Lock.ID != "" {
+		if t.State == "BLOCKED" {
+			return *t.Lock, WaitingToLock, true
+		}
+		if top := t.TopFrame(); top != nil && isPark(top.Method) {
+			return *t.Lock, Parking, true
+		}
+	}
+	return Lock{}, "", false
+}
+
+// WaitingOn returns the monitor the thread is waiting on in Object.wait
+func (t *Thread) WaitingOn() (Lock, bool) {
+	for _, f := range t.Frames {
+		for _, ev := range f.Locks {
+			if ev.Action == WaitingOn && ev.Lock.ID != "" {
+				return ev.Lock, true
+			}
+		}
+	}
+
+	if t.Lock != nil && t.Lock.ID != "" && strings.HasSuffix(t.State, "WAITING") {
+		if top := t.TopFrame(); top == nil || !isPark(top.Method) {
+			return *t.Lock, true
+		}
+	}
+	return Lock{}, false
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright The jattach authors
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package threaddump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// parseFile parses a thread dump from testdata
+func parseFile(t *testing.T, name string) *ThreadDump {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	d, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file    string
+		vm      string
+		threads []string
+	}{
+		{"hotspot8.txt", "OpenJDK 64-Bit Server VM (25.412-b08 mixed mode)",
+			[]string{"Attach Listener", "main", "VM Thread"}},
+		{"hotspot17.txt", "OpenJDK 64-Bit Server VM (17.0.11+9 mixed mode, sharing)",
+			[]string{"main", "Attach Listener", "VM Thread"}},
+		{"hotspot21.txt", "OpenJDK 64-Bit Server VM (21.0.3+9-LTS mixed mode, sharing)",
+			[]string{"main", "ForkJoinPool-1-worker-1", "Attach Listener", "VM Thread"}},
+		{"openj9.txt", "JRE 17 Linux amd64-64-Bit Compressed References 20240416_741 (JIT enabled, AOT enabled)",
+			[]string{"main", "Attach API wait loop"}},
+		{"openj9-diagnostics.txt", "JRE 17 Linux amd64-64-Bit Compressed References 20240416_741 (JIT enabled, AOT enabled)",
+			[]string{"main", "Attach API wait loop"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			d := parseFile(t, tt.file)
+			if d.VM != tt.vm {
+				t.Errorf("VM = %q, want %q", d.VM, tt.vm)
+			}
+			var names []string
+			for _, th := range d.Threads {
+				names = append(names, th.Name)
+			}
+			if len(names) != len(tt.threads) {
+				t.Fatalf("threads = %q, want %q", names, tt.threads)
+			}
+			for i := range names {
+				if names[i] != tt.threads[i] {
+					t.Errorf("threads = %q, want %q", names, tt.threads)
+				}
+			}
+
+			main := d.Find("main")
+			if main == nil || main.ID != 1 || main.State != "TIMED_WAITING" || main.Daemon {
+				t.Fatalf("main = %+v", main)
+			}
+			if bottom := main.Frames[len(main.Frames)-1]; bottom.Method != "Main.main" || bottom.Source != "Main.java:12" {
+				t.Errorf("bottom frame = %+v", bottom)
+			}
+		})
+	}
+}
+
+func TestParseCarrierThread(t *testing.T) {
+	d := parseFile(t, "hotspot21.txt")
+
+	carrier := d.Find("ForkJoinPool-1-worker-1")
+	if carrier == nil || carrier.CarriedThreadID != 21 || carrier.NID != 27960 || !carrier.Daemon {
+		t.Fatalf("carrier = %+v", carrier)
+	}
+	if f := carrier.TopFrame(); f == nil || f.Method != "jdk.internal.vm.Continuation.run" || f.Module != "java.base@21.0.3" {
+		t.Errorf("top frame = %+v", f)
+	}
+}
+
+func TestParseLocks(t *testing.T) {
+	d := parseFile(t, "locks.txt")
+
+	blocked := d.Find("pool-1-thread-1")
+	if blocked == nil || blocked.State != "BLOCKED" || blocked.NID != 0x101 || blocked.CPUMillis != 10 {
+		t.Fatalf("pool-1-thread-1 = %+v", blocked)
+	}
+	if lock, action, ok := blocked.BlockedOn(); !ok || action != WaitingToLock || lock.ID != "0x00000000c0000002" || lock.Class != "java.util.HashMap" {
+		t.Errorf("BlockedOn() = %+v, %s, %v", lock, action, ok)
+	}
+	held := blocked.Held()
+	if len(held) != 2 || held[0].Class != "Worker" || held[1].Class != "java.util.concurrent.locks.ReentrantLock$NonfairSync" {
+		t.Errorf("Held() = %+v", held)
+	}
+
+	parked := d.Find("pool-1-thread-2")
+	if lock, action, ok := parked.BlockedOn(); !ok || action != Parking || lock.ID != "0x00000000c0000010" {
+		t.Errorf("BlockedOn() = %+v, %s, %v", lock, action, ok)
+	}
+	if len(parked.Synchronizers) != 0 {
+		t.Errorf("synchronizers = %+v", parked.Synchronizers)
+	}
+
+	finalizer := d.Find("Finalizer")
+	if lock, ok := finalizer.WaitingOn(); !ok || lock.Class != "java.lang.ref.ReferenceQueue$Lock" {
+		t.Errorf("WaitingOn() = %+v, %v", lock, ok)
+	}
+	if _, _, ok := finalizer.BlockedOn(); ok {
+		t.Error("Finalizer is not blocked")
+	}
+}
+
+func TestDeadlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+
+		// contended is the most contended lock, blocking worker-3 and one
+		// of the threads of the cycle, and owner its owner
+		contended string
+		owner     string
+	}{
+		{"monitors", `Full thread dump OpenJDK 64-Bit Server VM (17.0.11+9 mixed mode, sharing):
+
+"worker-1" #20 prio=5 os_prio=0 tid=0x00007f0000001000 nid=0x101 waiting for monitor entry  [0x00007f0001000000]
+   java.lang.Thread.State: BLOCKED (on object monitor)
+	at Worker.transfer(Worker.java:10)
+	- waiting to lock <0x00000000c0000002> (a java.lang.Object)
+	- locked <0x00000000c0000001> (a java.lang.Object)
+	at Worker.run(Worker.java:5)
+
+"worker-2" #21 prio=5 os_prio=0 tid=0x00007f0000002000 nid=0x102 waiting for monitor entry  [0x00007f0002000000]
+   java.lang.Thread.State: BLOCKED (on object monitor)
+	at Worker.transfer(Worker.java:10)
+	- waiting to lock <0x00000000c0000001> (a java.lang.Object)
+	- locked <0x00000000c0000002> (a java.lang.Object)
+	at Worker.run(Worker.java:5)
+
+"worker-3" #22 prio=5 os_prio=0 tid=0x00007f0000003000 nid=0x103 waiting for monitor entry  [0x00007f0003000000]
+   java.lang.Thread.State: BLOCKED (on object monitor)
+	at Worker.audit(Worker.java:20)
+	- waiting to lock <0x00000000c0000001> (a java.lang.Object)
+
+`, "0x00000000c0000001", "worker-1"},
+
+		{"ReentrantLock", `Full thread dump OpenJDK 64-Bit Server VM (17.0.11+9 mixed mode, sharing):
+
+"worker-1" #20 prio=5 os_prio=0 tid=0x00007f0000001000 nid=0x101 waiting on condition  [0x00007f0001000000]
+   java.lang.Thread.State: WAITING (parking)
+	at jdk.internal.misc.Unsafe.park(java.base@17.0.11/Native Method)
+	- parking to wait for  <0x00000000c0000012> (a java.util.concurrent.locks.ReentrantLock$NonfairSync)
+	at java.util.concurrent.locks.LockSupport.park(java.base@17.0.11/LockSupport.java:211)
+	at java.util.concurrent.locks.ReentrantLock.lock(java.base@17.0.11/ReentrantLock.java:322)
+	at Worker.transfer(Worker.java:10)
+
+   Locked ownable synchronizers:
+	- <0x00000000c0000011> (a java.util.concurrent.locks.ReentrantLock$NonfairSync)
+
+"worker-2" #21 prio=5 os_prio=0 tid=0x00007f0000002000 nid=0x102 waiting on condition  [0x00007f0002000000]
+   java.lang.Thread.State: WAITING (parking)
+	at jdk.internal.misc.Unsafe.park(java.base@17.0.11/Native Method)
+	- parking to wait for  <0x00000000c0000011> (a java.util.concurrent.locks.ReentrantLock$NonfairSync)
+	at java.util.concurrent.locks.LockSupport.park(java.base@17.0.11/LockSupport.java:211)
+	at java.util.concurrent.locks.ReentrantLock.lock(java.base@17.0.11/ReentrantLock.java:322)
+	at Worker.transfer(Worker.java:10)
+
+   Locked ownable synchronizers:
+	- <0x00000000c0000012> (a java.util.concurrent.locks.ReentrantLock$NonfairSync)
+
+"worker-3" #22 prio=5 os_prio=0 tid=0x00007f0000003000 nid=0x103 waiting on condition  [0x00007f0003000000]
+   java.lang.Thread.State: WAITING (parking)
+	at jdk.internal.misc.Unsafe.park(java.base@17.0.11/Native Method)
+	- parking to wait for  <0x00000000c0000011> (a java.util.concurrent.locks.ReentrantLock$NonfairSync)
+	at java.util.concurrent.locks.LockSupport.park(java.base@17.0.11/LockSupport.java:211)
+	at Worker.audit(Worker.java:20)
+
+   Locked ownable synchronizers:
+	- None
+
+`, "0x00000000c0000011", "worker-1"},
+
+		{"OpenJ9", `JRE 17 Linux amd64-64-Bit Compressed References 20240416_741 (JIT enabled, AOT enabled)
+OpenJ9   - b04a1f1
+OMR      - 2c46f2b
+
+"worker-1" prio=5 Id=20 BLOCKED on java.lang.Object@2a1b3c4d owned by "worker-2" Id=21
+	at app//Worker.transfer(Worker.java:10)
+	-  blocked on java.lang.Object@2a1b3c4d
+	-  locked java.lang.Object@1f2e3d4c
+	at app//Worker.run(Worker.java:5)
+
+"worker-2" prio=5 Id=21 BLOCKED on java.lang.Object@1f2e3d4c owned by "worker-1" Id=20
+	at app//Worker.transfer(Worker.java:10)
+	-  blocked on java.lang.Object@1f2e3d4c
+	-  locked java.lang.Object@2a1b3c4d
+	at app//Worker.run(Worker.java:5)
+
+"worker-3" prio=5 Id=22 BLOCKED on java.lang.Object@1f2e3d4c owned by "worker-1" Id=20
+	at app//Worker.audit(Worker.java:20)
+	-  blocked on java.lang.Object@1f2e3d4c
+
+`, "1f2e3d4c", "worker-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ParseString(tt.dump)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := d.LockGraph()
+
+			deadlocks := g.Deadlocks()
+			if len(deadlocks) != 1 || len(deadlocks[0].Threads) != 2 {
+				t.Fatalf("deadlocks = %+v", deadlocks)
+			}
+			for _, th := range deadlocks[0].Threads {
+				if th.Name == "worker-3" {
+					t.Errorf("worker-3 is not in the cycle")
+				}
+			}
+
+			contended := g.Contended()
+			if len(contended) == 0 || contended[0].Lock.ID != tt.contended || contended[0].Blocked != 2 {
+				t.Fatalf("contended = %+v", contended)
+			}
+			if owner := contended[0].Owner; owner == nil || owner.Name != tt.owner {
+				t.Errorf("owner = %+v", owner)
+			}
+		})
+	}
+}
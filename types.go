@@ -5,7 +5,11 @@
 
 package jattach
 
-import "time"
+import (
+	"io"
+	"syscall"
+	"time"
+)
 
 // JVMType indicates the detected JVM implementation
 type JVMType int
@@ -19,6 +23,34 @@ const (
 	JVMTypeOpenJ9
 )
 
+// NamespaceMode selects how the container namespaces of the target are
+// handled
+type NamespaceMode int
+
+const (
+	// NamespaceAuto enters the net, ipc and mnt namespaces of the target
+	// and falls back to NamespaceNone if that is not permitted
+	NamespaceAuto NamespaceMode = iota
+	// NamespaceEnter enters the namespaces and fails if it cannot
+	NamespaceEnter
+	// NamespaceNone never calls setns. HotSpot is reached through
+	// /proc/<pid>/root and /proc/<pid>/cwd, which needs no CAP_SYS_ADMIN.
+	// OpenJ9, which connects over TCP and signals a SysV semaphore, can
+	// only be attached to if it shares the net and ipc namespaces
+	NamespaceNone
+)
+
+func (m NamespaceMode) String() string {
+	switch m {
+	case NamespaceEnter:
+		return "enter"
+	case NamespaceNone:
+		return "none"
+	default:
+		return "auto"
+	}
+}
+
 func (t JVMType) String() string {
 	switch t {
 	case JVMTypeHotSpot:
@@ -40,22 +72,109 @@ type Response struct {
 
 	// JVMType indicates which JVM type was detected
 	JVMType JVMType
+
+	// PID is the target process ID and NsPID the same process in its own
+	// PID namespace
+	PID   int
+	NsPID int
+
+	// Command and Args are the command sent to the JVM
+	Command string
+	Args    []string
+
+	// Timings are the durations of the attach phases, in order
+	Timings []Timing
+}
+
+// Timing is the duration of one phase of an attach operation
+// "resolve" covers the process lookup, the namespace and credential switch
+// and the JVM detection. HotSpot then goes through "wait_socket" (only if
+// the attach listener had to be started), "connect", "write_command",
+// "read_response" (the return code) and "read_body", OpenJ9 through
+// "attach_lock", "accept", "write_command", "read_response" and "detach"
+type Timing struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// ResponseStream is a JVM response whose body is read incrementally
+// It must be closed to release the connection to the JVM
+type ResponseStream struct {
+	// Code is the return code from the JVM operation (0 = success)
+	Code int
+
+	// JVMType indicates which JVM type was detected
+	JVMType JVMType
+
+	body io.ReadCloser
+}
+
+// Read reads the next chunk of the response body
+func (s *ResponseStream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+// Close releases the connection to the JVM
+func (s *ResponseStream) Close() error {
+	return s.body.Close()
 }
 
 // Options configures attach behavior
 type Options struct {
 	// PrintOutput controls whether JVM responses are printed to stdout
+	// It is ignored by AttachStream
 	PrintOutput bool
 
 	// TmpPath overrides the default temporary directory path
 	// Equivalent to JATTACH_PATH environment variable
 	TmpPath string
 
-	// Timeout for connection attempts (default: 6 seconds for HotSpot)
+	// ProcRoot is where the host procfs is mounted, for a sidecar that
+	// sees it at e.g. /host/proc. Every process lookup goes through it
+	// (default: JATTACH_PROC_ROOT environment variable, or /proc)
+	ProcRoot string
+
+	// NamespaceMode selects whether the namespaces of a containerized
+	// target are entered (default: NamespaceAuto)
+	NamespaceMode NamespaceMode
+
+	// ReplyAddress is the IP address on which OpenJ9 targets are awaited
+	// to connect back (default: both 127.0.0.1 and ::1, where OpenJ9
+	// connects depending on java.net.preferIPv6Addresses). Connections
+	// from other hosts are always rejected
+	ReplyAddress string
+
+	// Timeout bounds each phase of the attach sequence up to the command
+	// write: waiting for the attach socket, connecting, the OpenJ9 accept
+	// and the write itself (default: 6 seconds)
 	Timeout time.Duration
 
+	// ReadTimeout bounds the wait for the JVM response (default: Timeout)
+	// Commands like dumpheap may take longer on a large heap; a negative
+	// value waits without limit. A context deadline applies to every phase
+	// including the response read
+	ReadTimeout time.Duration
+
+	// Helper runs the namespace and credential switch in a short-lived
+	// copy of the current executable, which streams the response back over
+	// a pipe. Without it the calling process itself takes on the target's
+	// UID/GID for good, which is only acceptable for one-shot tools
+	Helper bool
+
+	// StateFile records the pending reverts of ChangeFlag and
+	// ChangeLogging (default: $XDG_STATE_HOME/jattach/changes.json or
+	// ~/.local/state/jattach/changes.json)
+	StateFile string
+
 	// Logger for diagnostic output (optional)
 	Logger Logger
+
+	// SignalHook, if set, delivers the SIGQUIT that starts the HotSpot
+	// attach listener instead of kill(2). It lets the fake JVMs of
+	// jattachtest, which run in the test process, receive the signal. A
+	// function cannot be passed to a helper process, so it cannot be
+	// combined with Helper
+	SignalHook func(pid int, sig syscall.Signal) error
 }
 
 // Logger interface for diagnostic output